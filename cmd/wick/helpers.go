@@ -25,12 +25,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/ini.v1"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/s-things/wick/core"
 )
 
 func getSerializerByName(name string) serialize.Serialization {
@@ -74,15 +82,17 @@ func userHomeDir() string {
 	return os.Getenv("HOME")
 }
 
-func readFromProfile(logger *logrus.Logger) {
+// readFromProfile sets the connection flags from the --profile section of
+// ~/.wick/config.
+func readFromProfile() error {
 	cfg, err := ini.Load(fmt.Sprintf("%s/.wick/config", userHomeDir()))
 	if err != nil {
-		logger.Fatalf("Fail to read config: %v", err)
+		return fmt.Errorf("failed to read config: %w", err)
 	}
 
 	section, err := cfg.GetSection(*profile)
 	if err != nil {
-		logger.Fatalf("Error in getting section: %s", err)
+		return fmt.Errorf("failed to read profile: %w", err)
 	}
 
 	*url = section.Key("url").Validate(func(s string) string {
@@ -98,6 +108,16 @@ func readFromProfile(logger *logrus.Logger) {
 		return s
 	})
 	*authid = section.Key("authid").String()
+	// TLS settings missing from the profile keep their command line values.
+	for key, value := range map[string]*string{"ca-cert": caCert, "client-cert": clientCert,
+		"client-key": clientKey, "tls-server-name": tlsServerName} {
+		if section.HasKey(key) {
+			*value = section.Key(key).String()
+		}
+	}
+	if section.HasKey("insecure-skip-verify") {
+		*insecureSkipVerify = section.Key("insecure-skip-verify").MustBool(false)
+	}
 	*authrole = section.Key("authrole").String()
 	*authMethod = section.Key("authmethod").String()
 	if *authMethod == "cryptosign" {
@@ -107,4 +127,319 @@ func readFromProfile(logger *logrus.Logger) {
 	} else if *authMethod == "wampcra" {
 		*secret = section.Key("secret").String()
 	}
+	return nil
+}
+
+// getDialer validates the connection flags and turns them into a core.Dialer.
+func getDialer(logger *logrus.Logger) (core.Dialer, error) {
+	if *privateKey != "" && *ticket != "" {
+		return core.Dialer{}, errors.New("provide only one of private key, ticket or secret")
+	} else if *ticket != "" && *secret != "" {
+		return core.Dialer{}, errors.New("provide only one of private key, ticket or secret")
+	} else if *privateKey != "" && *secret != "" {
+		return core.Dialer{}, errors.New("provide only one of private key, ticket or secret")
+	}
+
+	// auto decide authmethod if user didn't explicitly request
+	if *authMethod == "anonymous" {
+		*authMethod = selectAuthMethod(*privateKey, *ticket, *secret)
+	}
+
+	dialer := core.Dialer{
+		URL:        *url,
+		Realm:      *realm,
+		Serializer: getSerializerByName(*serializer),
+		AuthMethod: *authMethod,
+		AuthID:     *authid,
+		AuthRole:   *authrole,
+	}
+
+	switch *authMethod {
+	case "anonymous":
+		if *privateKey != "" {
+			return dialer, errors.New("private key not needed for anonymous auth")
+		}
+		if *ticket != "" {
+			return dialer, errors.New("ticket not needed for anonymous auth")
+		}
+		if *secret != "" {
+			return dialer, errors.New("secret not needed for anonymous auth")
+		}
+	case "ticket":
+		if *ticket == "" {
+			return dialer, errors.New("must provide ticket when authMethod is ticket")
+		}
+		dialer.Ticket = *ticket
+	case "wampcra":
+		if *secret == "" {
+			return dialer, errors.New("must provide secret when authMethod is wampcra")
+		}
+		dialer.Secret = *secret
+	case "cryptosign":
+		if *privateKey == "" {
+			return dialer, errors.New("must provide private key when authMethod is cryptosign")
+		}
+		dialer.PrivateKey = *privateKey
+	}
+
+	if *caCert != "" || *clientCert != "" || *clientKey != "" || *tlsServerName != "" || *insecureSkipVerify {
+		if !strings.HasPrefix(*url, "wss://") && !strings.HasPrefix(*url, "rss://") {
+			logger.Warn("TLS options are ignored for non-TLS URL ", *url)
+		}
+		tlsConfig, err := core.NewTLSConfig(*caCert, *clientCert, *clientKey, *tlsServerName, *insecureSkipVerify)
+		if err != nil {
+			return dialer, err
+		}
+		dialer.TLSConfig = tlsConfig
+	}
+
+	return dialer, nil
+}
+
+// stdinFlags take a file name where - reads stdin.
+var stdinFlags = map[string]bool{"--args-file": true, "--kwargs-file": true, "--payload-file": true}
+
+// stdinArgs rewrites - on the command line, which kingpin takes for a short
+// flag, so that it reads stdin: "--args-file -" becomes "--args-file=-" and a
+// trailing - argument is passed after "--".
+func stdinArgs(args []string) []string {
+	rewritten := make([]string, 0, len(args)+1)
+	for i, arg := range args {
+		if arg == "--" {
+			return append(rewritten, args[i:]...)
+		}
+
+		switch {
+		case arg != "-":
+			rewritten = append(rewritten, arg)
+		case i > 0 && stdinFlags[args[i-1]]:
+			rewritten[len(rewritten)-1] += "=-"
+		case i == len(args)-1:
+			rewritten = append(rewritten, "--", arg)
+		default:
+			rewritten = append(rewritten, arg)
+		}
+	}
+	return rewritten
+}
+
+// readPayloadFiles reads and merges the arguments from the payload file flags.
+// Stdin can only be read once, either by one of the files or by streaming.
+func readPayloadFiles(argsFile string, kwargsFile string, payloadFile string,
+	stream bool) (wamp.List, wamp.Dict, error) {
+
+	stdinReaders := 0
+	if stream {
+		stdinReaders++
+	}
+	for _, path := range []string{argsFile, kwargsFile, payloadFile} {
+		if path == "-" {
+			stdinReaders++
+		}
+	}
+	if stdinReaders > 1 {
+		return nil, nil, errors.New("stdin can only be used by one of --args-file, --kwargs-file, " +
+			"--payload-file or --stream")
+	}
+
+	var args wamp.List
+	kwargs := wamp.Dict{}
+
+	if payloadFile != "" {
+		payloadArgs, payloadKwargs, err := core.ReadPayload(payloadFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, payloadArgs...)
+		for key, value := range payloadKwargs {
+			kwargs[key] = value
+		}
+	}
+
+	if argsFile != "" {
+		fileArgs, err := core.ReadArgs(argsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, fileArgs...)
+	}
+
+	if kwargsFile != "" {
+		fileKwargs, err := core.ReadKwargs(kwargsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for key, value := range fileKwargs {
+			kwargs[key] = value
+		}
+	}
+
+	return args, kwargs, nil
+}
+
+// Exit codes, documented in the README.
+const (
+	exitError       = 1
+	exitConnection  = 2
+	exitAuth        = 3
+	exitNoProcedure = 4
+	exitTimeout     = 5
+	exitApplication = 6
+	exitInterrupted = 130
+)
+
+// exitCode maps an error returned by a command to the process exit code.
+func exitCode(err error) int {
+	var connectionErr *core.ConnectionError
+	var authErr *core.AuthError
+	var wampErr *core.WampError
+
+	switch {
+	case errors.As(err, &connectionErr):
+		return exitConnection
+	case errors.As(err, &authErr):
+		return exitAuth
+	case errors.As(err, &wampErr):
+		if wampErr.URI == wamp.ErrNoSuchProcedure {
+			return exitNoProcedure
+		}
+		if wampErr.Timeout() {
+			return exitTimeout
+		}
+		return exitApplication
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	}
+	return exitError
+}
+
+// reportError writes err, WAMP errors in the selected output format, and
+// returns the exit code for it.
+func reportError(err error, logger *logrus.Logger) int {
+	var wampErr *core.WampError
+	if errors.As(err, &wampErr) {
+		out := core.NewOutput(os.Stdout, core.Format(*output))
+		if writeErr := out.Error(wampErr); writeErr != nil {
+			logger.Errorln(writeErr)
+		}
+	} else {
+		logger.Errorln(err)
+	}
+
+	return exitCode(err)
+}
+
+// writeStats prints the report of a load test run to stderr and/or exports it
+// to path, as CSV if path ends with .csv and as JSON otherwise.
+func writeStats(stats *core.Stats, print bool, path string) error {
+	if stats == nil {
+		return nil
+	}
+
+	report := stats.Report()
+	if print {
+		if err := report.WriteText(os.Stderr); err != nil {
+			return err
+		}
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		err = report.WriteCSV(file)
+	} else {
+		err = report.WriteJSON(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// parseRate parses a rate such as 100, 100/s, 500/m or 10/h into requests per
+// second.
+func parseRate(rate string) (float64, error) {
+	count, unit := rate, "s"
+	if i := strings.Index(rate, "/"); i >= 0 {
+		count, unit = rate[:i], rate[i+1:]
+	}
+
+	per := map[string]float64{"s": 1, "m": 60, "h": 3600}[unit]
+	value, err := strconv.ParseFloat(count, 64)
+	if err != nil || per == 0 || value <= 0 {
+		return 0, fmt.Errorf("invalid rate %q, expected e.g. 100/s, 500/m or 10/h", rate)
+	}
+	return value / per, nil
+}
+
+func getLoad(rate string, duration time.Duration, warmUp time.Duration, rampUp time.Duration) (core.Load, error) {
+	load := core.Load{Duration: duration, WarmUp: warmUp, RampUp: rampUp}
+	if rate == "" {
+		return load, nil
+	}
+
+	var err error
+	load.Rate, err = parseRate(rate)
+	return load, err
+}
+
+// parseTopics turns topic arguments into topics to subscribe. A topic can be
+// prefixed with its match policy, e.g. prefix:com.myapp. or wildcard:com..update;
+// match applies to the others. Colons are not valid in WAMP URIs.
+func parseTopics(topics []string, match string) []core.Topic {
+	parsed := make([]core.Topic, len(topics))
+	for i, topic := range topics {
+		parsed[i] = core.Topic{URI: topic, Match: match}
+		for _, policy := range []string{"exact", "prefix", "wildcard"} {
+			if strings.HasPrefix(topic, policy+":") {
+				parsed[i] = core.Topic{URI: strings.TrimPrefix(topic, policy+":"), Match: policy}
+			}
+		}
+	}
+	return parsed
+}
+
+func parseExpectations(expectations []string) ([]core.Expectation, error) {
+	parsed := make([]core.Expectation, len(expectations))
+	for i, expectation := range expectations {
+		var err error
+		if parsed[i], err = core.ParseExpectation(expectation); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// parseReturnValues parses the --return-args and --return-kwargs of register,
+// empty values are not returned.
+func parseReturnValues(args string, kwargs string) (wamp.List, wamp.Dict, error) {
+	var returnArgs wamp.List
+	var returnKwargs wamp.Dict
+	var err error
+	if args != "" {
+		if returnArgs, err = core.ParseArgs(args); err != nil {
+			return nil, nil, fmt.Errorf("invalid --return-args: %w", err)
+		}
+	}
+	if kwargs != "" {
+		if returnKwargs, err = core.ParseKwargs(kwargs); err != nil {
+			return nil, nil, fmt.Errorf("invalid --return-kwargs: %w", err)
+		}
+	}
+	return returnArgs, returnKwargs, nil
+}
+
+// createRecording creates the file of subscribe --record. An existing file is
+// truncated, as replaying two runs would wait for the time between them.
+func createRecording(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 }
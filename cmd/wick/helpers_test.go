@@ -25,8 +25,17 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/s-things/wick/core"
 )
 
 func TestSerializerSelect(t *testing.T) {
@@ -78,3 +87,195 @@ func TestAutoSelectMethodAnony(t *testing.T) {
 		t.Error("default authmethod must be anonymous if no credentials provided")
 	}
 }
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{errors.New("invalid input"), exitError},
+		{&core.ConnectionError{URL: "ws://localhost:1", Err: errors.New("refused")}, exitConnection},
+		{&core.AuthError{Realm: "realm1", Err: errors.New("denied")}, exitAuth},
+		{&core.WampError{URI: wamp.ErrNoSuchProcedure}, exitNoProcedure},
+		{&core.WampError{URI: wamp.ErrCanceled, Args: wamp.List{"call timeout"}}, exitTimeout},
+		{fmt.Errorf("call: %w", context.DeadlineExceeded), exitTimeout},
+		{&core.WampError{URI: "app.error.invalid"}, exitApplication},
+		{fmt.Errorf("call: %w", context.Canceled), exitInterrupted},
+	}
+
+	for _, c := range cases {
+		if code := exitCode(c.err); code != c.code {
+			t.Errorf("wrong exit code for %v, expected=%d, got=%d", c.err, c.code, code)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	rates := map[string]float64{"100": 100, "100/s": 100, "120/m": 2, "36/h": 0.01}
+	for rate, expected := range rates {
+		if value, err := parseRate(rate); err != nil || value != expected {
+			t.Errorf("wrong rate for %s, expected=%v, got=%v (%v)", rate, expected, value, err)
+		}
+	}
+
+	for _, rate := range []string{"fast", "10/d", "0/s", "-5"} {
+		if _, err := parseRate(rate); err == nil {
+			t.Errorf("invalid rate %s accepted", rate)
+		}
+	}
+}
+
+func TestParseTopics(t *testing.T) {
+	topics := parseTopics([]string{"foo.bar", "prefix:com.myapp.", "wildcard:com..update"}, "exact")
+
+	expected := []core.Topic{{URI: "foo.bar", Match: "exact"}, {URI: "com.myapp.", Match: "prefix"},
+		{URI: "com..update", Match: "wildcard"}}
+	for i := range expected {
+		if topics[i] != expected[i] {
+			t.Errorf("wrong topic, expected=%+v, got=%+v", expected[i], topics[i])
+		}
+	}
+}
+
+// withStdin runs fn with os.Stdin reading data.
+func withStdin(t *testing.T, data string, fn func()) {
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	stdin := os.Stdin
+	os.Stdin = file
+	defer func() { os.Stdin = stdin }()
+	fn()
+}
+
+func TestPayloadFilesFromStdin(t *testing.T) {
+	for flag, data := range map[string]string{"--args-file": `[1, "a"]`, "--kwargs-file": `{"b": 2}`,
+		"--payload-file": `{"args": [1, "a"], "kwargs": {"b": 2}}`} {
+		*callArgsFile, *callKwargsFile, *callPayloadFile = "", "", ""
+		if _, err := kingpin.CommandLine.Parse(stdinArgs([]string{"call", "foo.bar", flag, "-"})); err != nil {
+			t.Fatalf("%s: %s", flag, err)
+		}
+
+		withStdin(t, data, func() {
+			args, kwargs, err := readPayloadFiles(*callArgsFile, *callKwargsFile, *callPayloadFile, false)
+			if err != nil {
+				t.Fatalf("%s: %s", flag, err)
+			}
+			if flag != "--kwargs-file" && (len(args) != 2 || args[1] != "a") {
+				t.Errorf("%s: unexpected args %v", flag, args)
+			}
+			if flag != "--args-file" && len(kwargs) != 1 {
+				t.Errorf("%s: unexpected kwargs %v", flag, kwargs)
+			}
+		})
+	}
+}
+
+func TestStdinArgs(t *testing.T) {
+	args := stdinArgs([]string{"publish", "foo", "--args-file", "-", "--kwargs-file=-", "-"})
+	if fmt.Sprint(args) != "[publish foo --args-file=- --kwargs-file=- -- -]" {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	args = stdinArgs([]string{"call", "foo", "--", "-", "--args-file", "-"})
+	if fmt.Sprint(args) != "[call foo -- - --args-file -]" {
+		t.Errorf("args after -- must be kept %v", args)
+	}
+}
+
+func TestMockSpecFromStdin(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse(stdinArgs([]string{"mock", "-"})); err != nil {
+		t.Fatal(err)
+	}
+
+	withStdin(t, "procedures:\n  - procedure: foo.bar\n    args: [1]\n", func() {
+		spec, err := core.ReadMockSpec(*mockSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(spec.Procedures) != 1 || spec.Procedures[0].Procedure != "foo.bar" {
+			t.Errorf("unexpected spec %+v", spec)
+		}
+	})
+}
+
+func TestCreateRecordingTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	for _, line := range []string{"first run\n", "second\n"} {
+		file, err := createRecording(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = file.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second\n" {
+		t.Errorf("earlier recording not replaced %q", data)
+	}
+}
+
+// writeProfile writes config as ~/.wick/config of a temporary home directory.
+func writeProfile(t *testing.T, config string) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	if err := os.MkdirAll(filepath.Join(home, ".wick"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".wick", "config"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadFromProfileErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USERPROFILE", os.Getenv("HOME"))
+	*profile = "test"
+	defer func() { *profile = "" }()
+
+	if err := readFromProfile(); err == nil {
+		t.Error("missing config must fail")
+	}
+
+	writeProfile(t, "[other]\nurl = ws://localhost:8080/ws\n")
+	err := readFromProfile()
+	if err == nil {
+		t.Fatal("missing profile must fail")
+	}
+	if code := exitCode(err); code != exitError {
+		t.Errorf("expected exit code %d, got %d", exitError, code)
+	}
+}
+
+func TestReadFromProfileKeepsTLSFlags(t *testing.T) {
+	writeProfile(t, "[test]\nurl = wss://localhost:8080/ws\nclient-cert = me.pem\n")
+	previousURL, previousRealm := *url, *realm
+	*profile, *caCert, *clientCert = "test", "ca.pem", "flag.pem"
+	defer func() {
+		*profile, *caCert, *clientCert, *url, *realm = "", "", "", previousURL, previousRealm
+	}()
+
+	if err := readFromProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if *caCert != "ca.pem" {
+		t.Errorf("--ca-cert must be kept when the profile has none, got %q", *caCert)
+	}
+	if *clientCert != "me.pem" {
+		t.Errorf("client-cert of the profile must be used, got %q", *clientCert)
+	}
+}
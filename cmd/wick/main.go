@@ -25,9 +25,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/gammazero/nexus/v3/client"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"io"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/s-things/wick/core"
@@ -53,14 +58,54 @@ var (
 	serializer = kingpin.Flag("serializer", "The serializer to use.").Envar("WICK_SERIALIZER").
 			Default("json").Enum("json", "msgpack", "cbor")
 	profile = kingpin.Flag("profile", "").Envar("WICK_PROFILE").String()
+	output  = kingpin.Flag("output", "Output format of events, invocations and call results.").
+		Envar("WICK_OUTPUT").Default("text").Enum("text", "json", "ndjson", "yaml", "raw")
 
-	subscribe             = kingpin.Command("subscribe", "Subscribe a topic.")
-	subscribeTopic        = subscribe.Arg("topic", "Topic to subscribe.").Required().String()
+	sessionCount = kingpin.Flag("sessions", "Number of sessions to join, calls, publishes and subscriptions "+
+		"are spread over them.").Default("1").Int()
+
+	caCert = kingpin.Flag("ca-cert", "PEM file with CA certificates to trust for wss:// and rss://.").
+		Envar("WICK_CA_CERT").String()
+	clientCert = kingpin.Flag("client-cert", "PEM client certificate for TLS client authentication.").
+			Envar("WICK_CLIENT_CERT").String()
+	clientKey = kingpin.Flag("client-key", "PEM private key of the client certificate.").
+			Envar("WICK_CLIENT_KEY").String()
+	tlsServerName = kingpin.Flag("tls-server-name", "Server name to verify the router certificate against.").
+			Envar("WICK_TLS_SERVER_NAME").String()
+	insecureSkipVerify = kingpin.Flag("insecure-skip-verify", "Do not verify the router certificate.").
+				Envar("WICK_INSECURE_SKIP_VERIFY").Bool()
+
+	reconnect = kingpin.Flag("reconnect", "Reconnect and resume subscribe or register when the router "+
+		"connection is lost.").Envar("WICK_RECONNECT").Bool()
+	reconnectDelay = kingpin.Flag("reconnect-delay", "Initial delay before reconnecting.").
+			Default("1s").Duration()
+	reconnectMaxDelay = kingpin.Flag("reconnect-max-delay", "Maximum delay between reconnect attempts.").
+				Default("30s").Duration()
+	reconnectMultiplier = kingpin.Flag("reconnect-multiplier", "Factor by which the delay grows after "+
+		"each failed attempt.").Default("2").Float64()
+	reconnectJitter = kingpin.Flag("reconnect-jitter", "Randomize each delay by up to this fraction.").
+			Default("0.2").Float64()
+	reconnectAttempts = kingpin.Flag("reconnect-attempts", "Give up after this many failed attempts "+
+		"(0 retries forever).").Default("0").Int()
+
+	subscribe       = kingpin.Command("subscribe", "Subscribe to topics.")
+	subscribeTopics = subscribe.Arg("topics", "Topics to subscribe, prefixed with prefix: or wildcard: "+
+		"to match them as a pattern.").Required().Strings()
+	subscribeMatch = subscribe.Flag("match", "How topics without a prefix: or wildcard: are matched.").
+			Enum("exact", "prefix", "wildcard")
 	subscribeOptions      = subscribe.Flag("option", "Subscribe option. (May be provided multiple times)").Short('o').StringMap()
 	subscribePrintDetails = subscribe.Flag("details", "Print event details.").Bool()
+	subscribeRecord       = subscribe.Flag("record", "Write every event with its time, topic and details "+
+		"to an NDJSON file for publish --replay.").String()
+	subscribeCount   = subscribe.Flag("count", "Exit after this many events, 1 if --expect is given.").Int()
+	subscribeTimeout = subscribe.Flag("timeout", "Exit after this long, failing if fewer than --count "+
+		"events arrived.").Duration()
+	subscribeExpect = subscribe.Flag("expect", "Only count events where path=value, e.g. args.0=5 or "+
+		"kwargs.user.name=john. (May be provided multiple times)").Strings()
 
-	publish            = kingpin.Command("publish", "Publish to a topic.")
-	publishTopic       = publish.Arg("topic", "Topic to publish.").Required().String()
+	publish      = kingpin.Command("publish", "Publish to a topic.")
+	publishTopic = publish.Arg("topic", "Topic to publish, with --replay it replaces the recorded topics.").
+			String()
 	publishArgs        = publish.Arg("args", "Provide the arguments.").Strings()
 	publishKeywordArgs = publish.Flag("kwarg", "Provide the keyword arguments.").Short('k').StringMap()
 	publishOptions     = publish.Flag("option", "Publish option. (May be provided multiple times)").Short('o').StringMap()
@@ -69,13 +114,53 @@ var (
 	delayPublish       = publish.Flag("delay", "Provide the delay in milliseconds.").Default("0").Int()
 	concurrentPublish  = publish.Flag("concurrency", "Publish to the topic concurrently. "+
 		"Only effective when called with --repeat.").Default("1").Int()
+	publishRawStrings = publish.Flag("raw-strings", "Send arguments without a type prefix (s:, i:, f:, b:, j:) "+
+		"as strings.").Bool()
+	publishArgsFile    = publish.Flag("args-file", "JSON or YAML file with a list of arguments, - reads stdin.").String()
+	publishKwargsFile  = publish.Flag("kwargs-file", "JSON or YAML file with keyword arguments, - reads stdin.").String()
+	publishPayloadFile = publish.Flag("payload-file", "JSON or YAML file with args and kwargs, - reads stdin.").String()
+	publishReplay      = publish.Flag("replay", "Publish the events recorded by subscribe --record with "+
+		"their original timing.").String()
+	publishSpeed  = publish.Flag("speed", "Speed multiplier of --replay.").Default("1").Float64()
+	publishStream = publish.Flag("stream", "Publish one event per NDJSON line read from stdin.").Bool()
+	publishRate   = publish.Flag("rate", "Publish at a steady rate such as 100/s, 500/m or 10/h, "+
+		"regardless of --concurrency.").String()
+	publishDuration    = publish.Flag("duration", "Publish for this long instead of --repeat times.").Duration()
+	publishWarmUp      = publish.Flag("warm-up", "Publish for this long before measuring.").Duration()
+	publishRampUp      = publish.Flag("ramp-up", "Raise the rate linearly from zero over this long.").Duration()
+	publishAcknowledge = publish.Flag("acknowledge", "Wait for the router to acknowledge every publish.").Bool()
+	publishStats       = publish.Flag("stats", "Print latency statistics to stderr after the run.").Bool()
+	publishStatsFile   = publish.Flag("stats-file", "Write latency statistics to a JSON or, with a .csv "+
+		"extension, CSV file.").String()
 
 	register          = kingpin.Command("register", "Register a procedure.")
 	registerProcedure = register.Arg("procedure", "Procedure name.").Required().String()
 	onInvocationCmd   = register.Arg("command", "Shell command to run and return it's output.").String()
 	delay             = register.Flag("delay", "Register procedure after delay.(in milliseconds)").Int()
 	invokeCount       = register.Flag("invoke-count", "Leave session after it's called requested times.").Int()
-	registerOptions   = register.Flag("option", "Procedure registration option. (May be provided multiple times)").Short('o').StringMap()
+	registerTemplate  = register.Flag("template", "Replace placeholders such as {{args.0}} or {{kwargs.name}} "+
+		"in the command with the shell quoted invocation values, or in --return-args and --return-kwargs "+
+		"with the invocation values.").Bool()
+	registerErrorURI = register.Flag("error-uri", "Error URI returned when the command exits with a "+
+		"non-zero status.").Default(string(core.ErrCommandFailed)).String()
+	registerParseJSON = register.Flag("parse-json", "Return JSON output as a value instead of a string, "+
+		`{"args": [...], "kwargs": {...}} sets both.`).Bool()
+	registerProgress = register.Flag("progress", "Send every line of output as a progressive result "+
+		"while the command runs.").Bool()
+	registerExecTimeout = register.Flag("exec-timeout", "Stop the command and fail the invocation with "+
+		"wamp.error.timeout when it runs longer.").Duration()
+	registerReturnArgs = register.Flag("return-args", "Return this JSON list to every caller instead of "+
+		"running a command.").String()
+	registerReturnKwargs = register.Flag("return-kwargs", "Return this JSON object to every caller instead "+
+		"of running a command.").String()
+	registerError = register.Flag("error", "Fail every invocation with this error URI, with "+
+		"--return-args and --return-kwargs as its payload.").String()
+	registerLatency        = register.Flag("latency", "Wait this long before returning a result.").Duration()
+	registerMaxConcurrency = register.Flag("max-concurrency", "Handle at most this many invocations at "+
+		"once, queue the others.").Int()
+	registerMaxQueue = register.Flag("max-queue", "Fail invocations with wick.error.queue_full when this "+
+		"many are queued, requires --max-concurrency.").Int()
+	registerOptions = register.Flag("option", "Procedure registration option. (May be provided multiple times)").Short('o').StringMap()
 
 	call            = kingpin.Command("call", "Call a procedure.")
 	callProcedure   = call.Arg("procedure", "Procedure to call.").Required().String()
@@ -87,93 +172,307 @@ var (
 	callOptions     = call.Flag("option", "Procedure call option. (May be provided multiple times)").Short('o').StringMap()
 	concurrentCalls = call.Flag("concurrency", "Make concurrent calls without waiting for the result for each to return. "+
 		"Only effective when called with --repeat.").Default("1").Int()
+	callResult = call.Flag("result", "Part of the result to print: the first positional result (the keyword "+
+		"results if there are none), all positional results, the keyword results or an envelope with both.").
+		Default("first-arg").
+		Enum("first-arg", "args", "kwargs", "envelope")
+	callResultDetails = call.Flag("details", "Print result details.").Bool()
+	callRawStrings    = call.Flag("raw-strings", "Send arguments without a type prefix (s:, i:, f:, b:, j:) "+
+		"as strings.").Bool()
+	callArgsFile    = call.Flag("args-file", "JSON or YAML file with a list of arguments, - reads stdin.").String()
+	callKwargsFile  = call.Flag("kwargs-file", "JSON or YAML file with keyword arguments, - reads stdin.").String()
+	callPayloadFile = call.Flag("payload-file", "JSON or YAML file with args and kwargs, - reads stdin.").String()
+	callTimeout     = call.Flag("timeout", "Cancel the call if it takes longer, also sent as the timeout option.").
+			Duration()
+	callCancelMode = call.Flag("cancel-mode", "How the callee is told about a canceled call.").
+			Default("killnowait").Enum("skip", "kill", "killnowait")
+	callRate = call.Flag("rate", "Call at a steady rate such as 100/s, 500/m or 10/h, "+
+		"regardless of --concurrency.").String()
+	callDuration  = call.Flag("duration", "Call for this long instead of --repeat times.").Duration()
+	callWarmUp    = call.Flag("warm-up", "Call for this long before measuring.").Duration()
+	callRampUp    = call.Flag("ramp-up", "Raise the rate linearly from zero over this long.").Duration()
+	callStats     = call.Flag("stats", "Print latency statistics to stderr after the run.").Bool()
+	callStatsFile = call.Flag("stats-file", "Write latency statistics to a JSON or, with a .csv "+
+		"extension, CSV file.").String()
+
+	latency         = kingpin.Command("latency", "Measure pub/sub delivery latency through the router.")
+	latencyTopic    = latency.Arg("topic", "Topic to publish and subscribe.").Default("wick.latency").String()
+	latencyRepeat   = latency.Flag("repeat", "Number of events to publish.").Default("100").Int()
+	latencyRate     = latency.Flag("rate", "Publish at a steady rate such as 100/s, 500/m or 10/h.").String()
+	latencyDuration = latency.Flag("duration", "Publish for this long instead of --repeat times.").Duration()
+	latencyWarmUp   = latency.Flag("warm-up", "Publish for this long before measuring.").Duration()
+	latencyWait     = latency.Flag("wait", "How long to wait for events in flight before counting them as lost.").
+			Default("1s").Duration()
+	latencySeparate = latency.Flag("separate-sessions", "Publish on a second session instead of the "+
+		"subscribing one.").Bool()
+
+	mock     = kingpin.Command("mock", "Mock procedures and topics described in a spec file.")
+	mockSpec = mock.Arg("spec", "YAML or JSON spec file, - reads stdin.").Required().String()
+
+	router           = kingpin.Command("router", "Start a local WAMP router.")
+	routerRealms     = router.Arg("realms", "Realms to serve.").Default("realm1").Strings()
+	routerWSAddress  = router.Flag("ws-address", "Address to serve WebSocket on, empty to disable.").Default("localhost:8080").String()
+	routerRSAddress  = router.Flag("rs-address", "Address to serve RawSocket on, empty to disable.").String()
+	routerAnonymous  = router.Flag("anonymous", "Allow anonymous authentication.").Default("true").Bool()
+	routerTickets    = router.Flag("ticket-user", "Allow ticket authentication as authid=ticket. (May be provided multiple times)").StringMap()
+	routerSecrets    = router.Flag("wampcra-user", "Allow wampcra authentication as authid=secret. (May be provided multiple times)").StringMap()
+	routerPublicKeys = router.Flag("cryptosign-user", "Allow cryptosign authentication as authid=public key hex. (May be provided multiple times)").StringMap()
+	routerRoles      = router.Flag("user-role", "Assign authrole to a user as authid=authrole, defaults to 'user'. (May be provided multiple times)").StringMap()
 )
 
 const versionString = "0.5.0"
 
 func main() {
 	kingpin.Version(versionString).VersionFlag.Short('v')
-	cmd := kingpin.Parse()
-
-	serializerToUse := getSerializerByName(*serializer)
+	cmd := kingpin.MustParse(kingpin.CommandLine.Parse(stdinArgs(os.Args[1:])))
 
 	logger := logrus.New()
 
-	if *profile != "" {
-		readFromProfile(logger)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, cmd, logger); err != nil {
+		stop()
+		os.Exit(reportError(err, logger))
+	}
+}
+
+func run(ctx context.Context, cmd string, logger *logrus.Logger) error {
+	if cmd == router.FullCommand() {
+		return core.ServeRouter(ctx, core.RouterConfig{
+			WebSocketAddress: *routerWSAddress,
+			RawSocketAddress: *routerRSAddress,
+			Realms:           *routerRealms,
+			Anonymous:        *routerAnonymous,
+			Tickets:          *routerTickets,
+			Secrets:          *routerSecrets,
+			PublicKeys:       *routerPublicKeys,
+			Roles:            *routerRoles,
+		})
 	}
 
-	if *privateKey != "" && *ticket != "" {
-		logger.Fatal("Provide only one of private key, ticket or secret")
-	} else if *ticket != "" && *secret != "" {
-		logger.Fatal("Provide only one of private key, ticket or secret")
-	} else if *privateKey != "" && *secret != "" {
-		logger.Fatal("Provide only one of private key, ticket or secret")
+	if *profile != "" {
+		if err := readFromProfile(); err != nil {
+			return err
+		}
 	}
 
-	// auto decide authmethod if user didn't explicitly request
-	if *authMethod == "anonymous" {
-		*authMethod = selectAuthMethod(*privateKey, *ticket, *secret)
+	dialer, err := getDialer(logger)
+	if err != nil {
+		return err
 	}
 
-	var session *client.Client
 	var startTime int64
 
 	if *logCallTime {
 		startTime = time.Now().UnixMilli()
 	}
-	switch *authMethod {
-	case "anonymous":
-		if *privateKey != "" {
-			logger.Fatal("Private key not needed for anonymous auth")
-		}
-		if *ticket != "" {
-			logger.Fatal("ticket not needed for anonymous auth")
-		}
-		if *secret != "" {
-			logger.Fatal("secret not needed for anonymous auth")
-		}
-		session = core.ConnectAnonymous(*url, *realm, serializerToUse, *authid, *authrole)
-	case "ticket":
-		if *ticket == "" {
-			logger.Fatal("Must provide ticket when authMethod is ticket")
-		}
-		session = core.ConnectTicket(*url, *realm, serializerToUse, *authid, *authrole, *ticket)
-	case "wampcra":
-		if *secret == "" {
-			logger.Fatal("Must provide secret when authMethod is wampcra")
+
+	var pool *core.Pool
+	var session *client.Client
+	if *sessionCount > 1 {
+		if pool, err = core.DialPool(ctx, dialer, *sessionCount); err != nil {
+			return err
 		}
-		session = core.ConnectCRA(*url, *realm, serializerToUse, *authid, *authrole, *secret)
-	case "cryptosign":
-		if *privateKey == "" {
-			logger.Fatal("Must provide private key when authMethod is cryptosign")
+		defer pool.Close()
+		session = pool.Sessions()[0]
+	} else {
+		if session, err = dialer.Dial(ctx); err != nil {
+			return err
 		}
-		session = core.ConnectCryptoSign(*url, *realm, serializerToUse, *authid, *authrole, *privateKey)
+		defer session.Close()
 	}
 
 	if *logCallTime {
 		endTime := time.Now().UnixMilli()
-		logger.Printf("session joined in %dms\n", endTime-startTime)
+		if pool != nil {
+			report := pool.JoinReport()
+			logger.Printf("%d sessions joined in %dms, join latency min=%.3fms mean=%.3fms p99=%.3fms "+
+				"max=%.3fms\n", report.Requests, endTime-startTime, report.MinMs, report.MeanMs, report.P99Ms,
+				report.MaxMs)
+		} else {
+			logger.Printf("session joined in %dms\n", endTime-startTime)
+		}
+	}
+
+	var reconnectConfig *core.Reconnect
+	if *reconnect {
+		reconnectConfig = &core.Reconnect{
+			Dialer: dialer,
+			Backoff: core.Backoff{
+				InitialDelay: *reconnectDelay,
+				MaxDelay:     *reconnectMaxDelay,
+				Multiplier:   *reconnectMultiplier,
+				Jitter:       *reconnectJitter,
+				MaxAttempts:  *reconnectAttempts,
+			},
+		}
 	}
 
-	defer session.Close()
+	out := core.NewOutput(os.Stdout, core.Format(*output))
 
 	switch cmd {
 	case subscribe.FullCommand():
-		core.Subscribe(session, *subscribeTopic, *subscribeOptions, *subscribePrintDetails)
+		expectations, err := parseExpectations(*subscribeExpect)
+		if err != nil {
+			return err
+		}
+		count := *subscribeCount
+		if count == 0 && len(expectations) != 0 {
+			count = 1
+		}
+		var record io.Writer
+		if *subscribeRecord != "" {
+			file, err := createRecording(*subscribeRecord)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			record = file
+		}
+		return core.Subscribe(ctx, session, out, core.SubscribeParams{
+			Topics:       parseTopics(*subscribeTopics, *subscribeMatch),
+			Options:      *subscribeOptions,
+			PrintDetails: *subscribePrintDetails,
+			Reconnect:    reconnectConfig,
+			Pool:         pool,
+			Count:        count,
+			Timeout:      *subscribeTimeout,
+			Expect:       expectations,
+			Record:       record,
+		})
 	case publish.FullCommand():
-		if *repeatPublish < 1 {
-			logger.Fatal("repeat count must be greater than zero")
+		params := core.PublishParams{
+			Topic:       *publishTopic,
+			Args:        *publishArgs,
+			Kwargs:      *publishKeywordArgs,
+			Options:     *publishOptions,
+			LogTime:     *logPublishTime,
+			Repeat:      *repeatPublish,
+			Concurrency: *concurrentPublish,
+			Delay:       time.Duration(*delayPublish) * time.Millisecond,
+			RawStrings:  *publishRawStrings,
+			Pool:        pool,
+			Acknowledge: *publishAcknowledge,
+		}
+		if params.Load, err = getLoad(*publishRate, *publishDuration, *publishWarmUp, *publishRampUp); err != nil {
+			return err
+		}
+		if params.ExtraArgs, params.ExtraKwargs, err = readPayloadFiles(*publishArgsFile, *publishKwargsFile,
+			*publishPayloadFile, *publishStream); err != nil {
+			return err
 		}
-		core.Publish(session, *publishTopic, *publishArgs, *publishKeywordArgs, *publishOptions, *logPublishTime,
-			*repeatPublish, *delayPublish, *concurrentPublish)
+		if *publishStream {
+			params.Stream = os.Stdin
+		}
+		if *publishReplay != "" {
+			file, err := os.Open(*publishReplay)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			params.Replay = file
+			params.Speed = *publishSpeed
+		} else if params.Topic == "" {
+			return errors.New("topic is required unless publishing with --replay")
+		}
+		if *publishStats || *publishStatsFile != "" {
+			params.Stats = core.NewStats()
+		}
+		err = core.Publish(ctx, session, params)
+		if statsErr := writeStats(params.Stats, *publishStats, *publishStatsFile); statsErr != nil {
+			logger.Errorln(statsErr)
+		}
+		return err
 	case register.FullCommand():
-		core.Register(session, *registerProcedure, *onInvocationCmd, *delay, *invokeCount, *registerOptions)
+		if pool != nil {
+			logger.Warnln("register only uses the first session of --sessions")
+		}
+		returnArgs, returnKwargs, err := parseReturnValues(*registerReturnArgs, *registerReturnKwargs)
+		if err != nil {
+			return err
+		}
+		return core.Register(ctx, session, out, core.RegisterParams{
+			Procedure:      *registerProcedure,
+			Command:        *onInvocationCmd,
+			Template:       *registerTemplate,
+			ErrorURI:       *registerErrorURI,
+			ParseJSON:      *registerParseJSON,
+			Progress:       *registerProgress,
+			ExecTimeout:    *registerExecTimeout,
+			ReturnArgs:     returnArgs,
+			ReturnKwargs:   returnKwargs,
+			ReturnError:    *registerError,
+			Latency:        *registerLatency,
+			MaxConcurrency: *registerMaxConcurrency,
+			MaxQueue:       *registerMaxQueue,
+			Options:        *registerOptions,
+			Delay:          time.Duration(*delay) * time.Millisecond,
+			InvokeCount:    *invokeCount,
+			Reconnect:      reconnectConfig,
+		})
 	case call.FullCommand():
-		if *repeatCount < 1 {
-			logger.Fatal("repeat count must be greater than zero")
+		params := core.CallParams{
+			Procedure:     *callProcedure,
+			Args:          *callArgs,
+			Kwargs:        *callKeywordArgs,
+			Options:       *callOptions,
+			LogTime:       *logCallTime,
+			Repeat:        *repeatCount,
+			Concurrency:   *concurrentCalls,
+			Delay:         time.Duration(*delayCall) * time.Millisecond,
+			Result:        core.ResultShape(*callResult),
+			ResultDetails: *callResultDetails,
+			RawStrings:    *callRawStrings,
+			Timeout:       *callTimeout,
+			CancelMode:    *callCancelMode,
+			Pool:          pool,
 		}
-		core.Call(session, *callProcedure, *callArgs, *callKeywordArgs, *logCallTime, *repeatCount, *delayCall,
-			*concurrentCalls, *callOptions)
+		if params.Load, err = getLoad(*callRate, *callDuration, *callWarmUp, *callRampUp); err != nil {
+			return err
+		}
+		if params.ExtraArgs, params.ExtraKwargs, err = readPayloadFiles(*callArgsFile, *callKwargsFile,
+			*callPayloadFile, false); err != nil {
+			return err
+		}
+		if *callStats || *callStatsFile != "" {
+			params.Stats = core.NewStats()
+		}
+		err = core.Call(ctx, session, out, params)
+		if statsErr := writeStats(params.Stats, *callStats, *callStatsFile); statsErr != nil {
+			logger.Errorln(statsErr)
+		}
+		return err
+	case latency.FullCommand():
+		params := core.LatencyParams{
+			Topic:  *latencyTopic,
+			Repeat: *latencyRepeat,
+			Wait:   *latencyWait,
+		}
+		if params.Load, err = getLoad(*latencyRate, *latencyDuration, *latencyWarmUp, 0); err != nil {
+			return err
+		}
+
+		publisher := session
+		if *latencySeparate {
+			if publisher, err = dialer.Dial(ctx); err != nil {
+				return err
+			}
+			defer publisher.Close()
+		}
+
+		_, err = core.MeasureLatency(ctx, session, publisher, out, params)
+		return err
+	case mock.FullCommand():
+		if pool != nil {
+			logger.Warnln("mock only uses the first session of --sessions")
+		}
+		spec, err := core.ReadMockSpec(*mockSpec)
+		if err != nil {
+			return err
+		}
+		return core.Mock(ctx, session, out, spec)
 	}
+
+	return nil
 }
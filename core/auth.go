@@ -85,11 +85,14 @@ func getCRAAuthConfig(realm string, serializer serialize.Serialization, authid s
 }
 
 func getCryptosignAuthConfig(realm string, serializer serialize.Serialization, authid string, authrole string,
-	privateKey string) client.Config {
+	privateKey string) (client.Config, error) {
 
 	hello := getBaseHello(authid, authrole)
 
-	publicKey, pvk := getKeyPair(privateKey)
+	publicKey, pvk, err := getKeyPair(privateKey)
+	if err != nil {
+		return client.Config{}, err
+	}
 	// Extend hello details with pubkey
 	hello["authextra"] = wamp.Dict{"pubkey": hex.EncodeToString(publicKey)}
 
@@ -103,5 +106,5 @@ func getCryptosignAuthConfig(realm string, serializer serialize.Serialization, a
 		Serialization: serializer,
 	}
 
-	return cfg
+	return cfg, nil
 }
@@ -90,7 +90,10 @@ func TestConnectCRA(t *testing.T) {
 }
 
 func TestConnectCryptoSign(t *testing.T) {
-	cfg := getCryptosignAuthConfig(realm, serializer, authId, authRole, privateKeyHex)
+	cfg, err := getCryptosignAuthConfig(realm, serializer, authId, authRole, privateKeyHex)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	checkBaseConfig(cfg, t)
 
@@ -101,7 +104,7 @@ func TestConnectCryptoSign(t *testing.T) {
 }
 
 func TestHandleCryptosign(t *testing.T) {
-	_, pvk := getKeyPair(privateKeyHex)
+	_, pvk, _ := getKeyPair(privateKeyHex)
 	callable := handleCryptosign(pvk)
 
 	challengeHex := "a1d483092ec08960fedbaed2bc1d411568a59077b794210e251bd3abb1563f7c"
@@ -0,0 +1,160 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// ErrCommandFailed is the default error URI of a failed register command.
+const ErrCommandFailed = wamp.URI("wick.error.command_failed")
+
+// runCommand runs params.Command for the invocation and turns its outcome into
+// the result of the invocation. If progress is set, every line of output is
+// sent with it as a progressive result while the command runs. The command is
+// stopped when ctx is done or params.ExecTimeout passes.
+func runCommand(ctx context.Context, params RegisterParams, inv *wamp.Invocation,
+	progress func(args wamp.List, kwargs wamp.Dict) error) client.InvokeResult {
+	data := newInvocation(params.Procedure, inv)
+
+	command := params.Command
+	if params.Template {
+		command = data.expand(command, shellQuote)
+	}
+
+	stdin, err := data.stdin()
+	if err != nil {
+		return commandError(ctx, params, err, "", "")
+	}
+	env, err := data.env()
+	if err != nil {
+		return commandError(ctx, params, err, "", "")
+	}
+
+	if params.ExecTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.ExecTimeout)
+		defer cancel()
+	}
+
+	if progress != nil {
+		err, stderr := shellOutLines(ctx, command, stdin, env, func(line string) {
+			result := commandResult(params, line)
+			if err := progress(result.Args, result.Kwargs); err != nil {
+				logger.Errorln("sending progress failed:", err)
+			}
+		})
+		if err != nil {
+			return commandError(ctx, params, err, "", stderr)
+		}
+		return client.InvokeResult{}
+	}
+
+	err, stdout, stderr := shellOut(ctx, command, stdin, env)
+	if err != nil {
+		return commandError(ctx, params, err, stdout, stderr)
+	}
+	return commandResult(params, stdout)
+}
+
+// commandError returns a failed command as a WAMP error with its exit code,
+// stdout and stderr in kwargs. A command stopped because the invocation was
+// canceled fails with wamp.error.canceled, one that ran longer than
+// params.ExecTimeout with wamp.error.timeout.
+func commandError(ctx context.Context, params RegisterParams, err error, stdout string,
+	stderr string) client.InvokeResult {
+	logger.Println("error: ", err)
+
+	uri := wamp.URI(params.ErrorURI)
+	if uri == "" {
+		uri = ErrCommandFailed
+	}
+	message := fmt.Sprintf("command failed: %s", err)
+
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		uri = errTimeout
+		message = fmt.Sprintf("command timed out after %s", params.ExecTimeout)
+	case context.Canceled:
+		uri = wamp.ErrCanceled
+		message = "command canceled"
+	}
+
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+
+	return client.InvokeResult{
+		Err:  uri,
+		Args: wamp.List{message},
+		Kwargs: wamp.Dict{
+			"exit_code": exitCode,
+			"stdout":    stdout,
+			"stderr":    stderr,
+		},
+	}
+}
+
+// commandResult returns stdout as the only result, or with params.ParseJSON
+// the JSON value it holds.
+func commandResult(params RegisterParams, stdout string) client.InvokeResult {
+	if !params.ParseJSON {
+		return client.InvokeResult{Args: wamp.List{stdout}}
+	}
+
+	value, err := decodeJSON([]byte(strings.TrimSpace(stdout)))
+	if err != nil {
+		return client.InvokeResult{Args: wamp.List{stdout}}
+	}
+
+	if dict, ok := value.(map[string]interface{}); ok && isEnvelope(dict) {
+		if args, kwargs, err := payloadFromDocument(dict); err == nil {
+			return client.InvokeResult{Args: args, Kwargs: kwargs}
+		}
+	}
+	return client.InvokeResult{Args: wamp.List{value}}
+}
+
+// isEnvelope reports whether dict only has args and kwargs keys.
+func isEnvelope(dict map[string]interface{}) bool {
+	if len(dict) == 0 {
+		return false
+	}
+	for key := range dict {
+		if key != "args" && key != "kwargs" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestCommandResultParseJSON(t *testing.T) {
+	params := RegisterParams{ParseJSON: true}
+
+	result := commandResult(params, `{"args": [1], "kwargs": {"k": "v"}}`+"\n")
+	if len(result.Args) != 1 || result.Args[0] != int64(1) || result.Kwargs["k"] != "v" {
+		t.Errorf("envelope not parsed %+v", result)
+	}
+
+	result = commandResult(params, `{"name": "john"}`)
+	if dict, ok := result.Args[0].(map[string]interface{}); !ok || dict["name"] != "john" {
+		t.Errorf("object not returned as value %+v", result)
+	}
+
+	result = commandResult(params, "plain text\n")
+	if result.Args[0] != "plain text\n" {
+		t.Errorf("text must be returned as is %+v", result)
+	}
+
+	result = commandResult(RegisterParams{}, "[1, 2]")
+	if result.Args[0] != "[1, 2]" {
+		t.Errorf("JSON must only be parsed on request %+v", result)
+	}
+}
+
+func TestRegisterCommandFailure(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	callee := connectTestSession(t, url)
+	caller := connectTestSession(t, url)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Register(ctx, callee, NewOutput(&bytes.Buffer{}, FormatText), RegisterParams{Procedure: "foo.fail",
+		Command: "echo broken >&2; exit 3", ErrorURI: "app.error.backend"})
+
+	// give the registration time to be established
+	time.Sleep(100 * time.Millisecond)
+
+	err := Call(context.Background(), caller, NewOutput(&bytes.Buffer{}, FormatText), CallParams{
+		Procedure: "foo.fail", Repeat: 1})
+
+	var wampError *WampError
+	if !errors.As(err, &wampError) {
+		t.Fatalf("expected WampError, got %v", err)
+	}
+	if wampError.URI != "app.error.backend" {
+		t.Errorf("wrong error URI %s", wampError.URI)
+	}
+	if code, _ := wamp.AsInt64(wampError.Kwargs["exit_code"]); code != 3 || wampError.Kwargs["stderr"] != "broken\n" {
+		t.Errorf("unexpected kwargs %v", wampError.Kwargs)
+	}
+}
+
+func TestRegisterCommandProgress(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	callee := connectTestSession(t, url)
+	caller := connectTestSession(t, url)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Register(ctx, callee, NewOutput(&bytes.Buffer{}, FormatText), RegisterParams{Procedure: "foo.progress",
+		Command: `echo '{"step": 1}'; echo '{"step": 2}'`, ParseJSON: true, Progress: true})
+
+	// give the registration time to be established
+	time.Sleep(100 * time.Millisecond)
+
+	var buffer bytes.Buffer
+	err := Call(context.Background(), caller, NewOutput(&buffer, FormatNDJSON), CallParams{
+		Procedure: "foo.progress", Repeat: 1, Options: map[string]string{"receive_progress": "true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []string{`{"step":1}`, `{"step":2}`} {
+		if !strings.Contains(buffer.String(), step) {
+			t.Errorf("missing progress %s in %q", step, buffer.String())
+		}
+	}
+}
+
+func TestRegisterCommandExecTimeout(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	callee := connectTestSession(t, url)
+	caller := connectTestSession(t, url)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// sleep runs in a child of bash, which holds stdout open unless it is
+	// stopped along with bash.
+	go Register(ctx, callee, NewOutput(&bytes.Buffer{}, FormatText), RegisterParams{Procedure: "foo.slow",
+		Command: "sleep 10; echo done", ExecTimeout: 200 * time.Millisecond})
+
+	// give the registration time to be established
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	err := Call(context.Background(), caller, NewOutput(&bytes.Buffer{}, FormatText), CallParams{
+		Procedure: "foo.slow", Repeat: 1})
+
+	var wampError *WampError
+	if !errors.As(err, &wampError) || !wampError.Timeout() {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("command was not stopped, call took %s", elapsed)
+	}
+}
+
+func TestRegisterCommandCanceled(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	callee := connectTestSession(t, url)
+	caller := connectTestSession(t, url)
+
+	marker := filepath.Join(t.TempDir(), "terminated")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Register(ctx, callee, NewOutput(&bytes.Buffer{}, FormatText), RegisterParams{Procedure: "foo.slow",
+		Command: "trap 'touch " + marker + "; exit 1' TERM; sleep 10 & wait"})
+
+	// give the registration time to be established
+	time.Sleep(100 * time.Millisecond)
+
+	_ = Call(context.Background(), caller, NewOutput(&bytes.Buffer{}, FormatText), CallParams{
+		Procedure: "foo.slow", Repeat: 1, Timeout: 200 * time.Millisecond, CancelMode: wamp.CancelModeKill})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(marker); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("command was not terminated")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
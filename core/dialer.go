@@ -0,0 +1,140 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Dialer holds everything needed to establish a session, so that the same
+// session can be re-established after the router connection is lost.
+type Dialer struct {
+	URL        string
+	Realm      string
+	Serializer serialize.Serialization
+	AuthMethod string
+	AuthID     string
+	AuthRole   string
+	Secret     string
+	Ticket     string
+	PrivateKey string
+	// TLSConfig is used for wss:// and rss:// URLs, nil uses the defaults.
+	TLSConfig *tls.Config
+}
+
+func (d Dialer) authConfig() (client.Config, error) {
+	switch d.AuthMethod {
+	case "ticket":
+		return getTicketAuthConfig(d.Realm, d.Serializer, d.AuthID, d.AuthRole, d.Ticket), nil
+	case "wampcra":
+		return getCRAAuthConfig(d.Realm, d.Serializer, d.AuthID, d.AuthRole, d.Secret), nil
+	case "cryptosign":
+		return getCryptosignAuthConfig(d.Realm, d.Serializer, d.AuthID, d.AuthRole, d.PrivateKey)
+	}
+	return getAnonymousAuthConfig(d.Realm, d.Serializer, d.AuthID, d.AuthRole), nil
+}
+
+func (d Dialer) config() (client.Config, error) {
+	cfg, err := d.authConfig()
+	if err != nil {
+		return cfg, err
+	}
+
+	if isSecureURL(d.URL) {
+		cfg.TlsCfg = d.TLSConfig
+	}
+	return cfg, nil
+}
+
+// Dial connects to the router and joins the realm. It returns a
+// *ConnectionError if the router cannot be reached and an *AuthError if the
+// router does not let the session join.
+func (d Dialer) Dial(ctx context.Context) (*client.Client, error) {
+	cfg, err := d.config()
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := connectPeer(ctx, sanitizeURL(d.URL), cfg)
+	if err != nil {
+		return nil, &ConnectionError{URL: d.URL, Err: err}
+	}
+
+	session, err := client.NewClient(peer, cfg)
+	if err != nil {
+		return nil, &AuthError{Realm: d.Realm, Err: err}
+	}
+
+	return session, nil
+}
+
+// connectPeer establishes the transport the same way client.ConnectNet does,
+// so that transport failures can be told apart from failures to join.
+func connectPeer(ctx context.Context, routerURL string, cfg client.Config) (wamp.Peer, error) {
+	u, err := url.Parse(routerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Scheme == "http" {
+			u.Scheme = "ws"
+		} else {
+			u.Scheme = "wss"
+		}
+		routerURL = u.String()
+		fallthrough
+	case "ws", "wss":
+		return transport.ConnectWebsocketPeer(ctx, routerURL, cfg.Serialization, cfg.TlsCfg, cfg.Logger, &cfg.WsCfg)
+	case "tcps", "tcp4s", "tcp6s":
+		u.Scheme = strings.TrimSuffix(u.Scheme, "s")
+		if cfg.TlsCfg == nil {
+			cfg.TlsCfg = new(tls.Config)
+		}
+		fallthrough
+	case "tcp", "tcp4", "tcp6":
+		return transport.ConnectRawSocketPeer(ctx, u.Scheme, u.Host, cfg.Serialization, cfg.TlsCfg, cfg.Logger,
+			cfg.RecvLimit)
+	case "unix":
+		if cfg.TlsCfg != nil {
+			return nil, fmt.Errorf("tls not supported for %s", u.Scheme)
+		}
+		return transport.ConnectRawSocketPeer(ctx, u.Scheme, path.Clean(u.Host+u.Path), cfg.Serialization, nil,
+			cfg.Logger, cfg.RecvLimit)
+	}
+
+	return nil, fmt.Errorf("unsupported url scheme: %s", u.Scheme)
+}
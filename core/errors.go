@@ -0,0 +1,108 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"fmt"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// ConnectionError is returned when the router cannot be reached or the
+// connection to it is lost for good.
+type ConnectionError struct {
+	URL string
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	if e.URL == "" {
+		return fmt.Sprintf("connection failed: %s", e.Err)
+	}
+	return fmt.Sprintf("connection to %s failed: %s", e.URL, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned when the router refuses to let the session join the
+// realm, typically because of wrong credentials.
+type AuthError struct {
+	Realm string
+	Err   error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("joining realm %s failed: %s", e.Realm, e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// WampError is an ERROR message returned by the router or a callee. Topic is
+// set instead of Procedure for a rejected publish.
+type WampError struct {
+	Procedure string
+	Topic     string
+	URI       wamp.URI
+	Args      wamp.List
+	Kwargs    wamp.Dict
+	Details   wamp.Dict
+}
+
+func (e *WampError) Error() string {
+	if e.Topic != "" {
+		return fmt.Sprintf("publishing to %s failed: %s", e.Topic, e.URI)
+	}
+	return fmt.Sprintf("calling %s failed: %s", e.Procedure, e.URI)
+}
+
+// errTimeout is the URI of calls that were given up on after a timeout.
+const errTimeout = wamp.URI("wamp.error.timeout")
+
+// Timeout reports whether the call was canceled because it ran out of time.
+// Nexus cancels timed out calls with "call timeout" as argument, Crossbar
+// uses its own URI.
+func (e *WampError) Timeout() bool {
+	if e.URI == errTimeout {
+		return true
+	}
+	return e.URI == wamp.ErrCanceled && len(e.Args) != 0 && e.Args[0] == "call timeout"
+}
+
+// SerializationError is returned when a payload cannot be encoded for output.
+type SerializationError struct {
+	Err error
+}
+
+func (e *SerializationError) Error() string {
+	return fmt.Sprintf("serialization failed: %s", e.Err)
+}
+
+func (e *SerializationError) Unwrap() error {
+	return e.Err
+}
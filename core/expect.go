@@ -0,0 +1,120 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Expectation matches the value at a path in the args or kwargs of an event,
+// e.g. args.0=5, kwargs.user.name="john" or args=["hello"].
+type Expectation struct {
+	// Path starts with args or kwargs, followed by list indexes and dict keys.
+	Path  []string
+	Value interface{}
+}
+
+// ParseExpectation parses path=value, where value is JSON or, if it is not
+// valid JSON, a string.
+func ParseExpectation(expectation string) (Expectation, error) {
+	parts := strings.SplitN(expectation, "=", 2)
+	if len(parts) != 2 {
+		return Expectation{}, fmt.Errorf("invalid expectation %q, expected path=value", expectation)
+	}
+
+	path := strings.Split(parts[0], ".")
+	if path[0] != "args" && path[0] != "kwargs" {
+		return Expectation{}, fmt.Errorf("invalid expectation %q, path must start with args or kwargs",
+			expectation)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(parts[1]), &value); err != nil {
+		value = parts[1]
+	}
+	return Expectation{Path: path, Value: value}, nil
+}
+
+// Match reports whether the value at the path equals the expected value.
+// Values are compared by their JSON encoding, so 5 matches 5.0.
+func (e Expectation) Match(args wamp.List, kwargs wamp.Dict) bool {
+	var value interface{} = kwargs
+	if e.Path[0] == "args" {
+		value = args
+	}
+
+	for _, key := range e.Path[1:] {
+		var ok bool
+		if value, ok = lookup(value, key); !ok {
+			return false
+		}
+	}
+
+	actual, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	expected, err := json.Marshal(e.Value)
+	return err == nil && string(actual) == string(expected)
+}
+
+func lookup(value interface{}, key string) (interface{}, bool) {
+	switch v := value.(type) {
+	case wamp.List:
+		return index(v, key)
+	case []interface{}:
+		return index(v, key)
+	case wamp.Dict:
+		item, ok := v[key]
+		return item, ok
+	case map[string]interface{}:
+		item, ok := v[key]
+		return item, ok
+	}
+	return nil, false
+}
+
+func index(list []interface{}, key string) (interface{}, bool) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || i >= len(list) {
+		return nil, false
+	}
+	return list[i], true
+}
+
+// matchAll reports whether the event meets all expectations.
+func matchAll(expectations []Expectation, args wamp.List, kwargs wamp.Dict) bool {
+	for _, expectation := range expectations {
+		if !expectation.Match(args, kwargs) {
+			return false
+		}
+	}
+	return true
+}
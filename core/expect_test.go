@@ -0,0 +1,69 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestExpectationMatch(t *testing.T) {
+	args := wamp.List{5, "hello", []interface{}{1, 2}}
+	kwargs := wamp.Dict{"user": map[string]interface{}{"name": "john", "age": 30.0}}
+
+	cases := map[string]bool{
+		"args.0=5":                true,
+		"args.0=5.0":              true,
+		"args.1=hello":            true,
+		`args.1="hello"`:          true,
+		"args.2=[1,2]":            true,
+		`args=[5,"hello",[1,2]]`:  true,
+		"kwargs.user.name=john":   true,
+		"kwargs.user.age=30":      true,
+		"args.0=6":                false,
+		"args.3=5":                false,
+		"kwargs.user.email=john":  false,
+		`kwargs={"user":"other"}`: false,
+	}
+
+	for expression, expected := range cases {
+		expectation, err := ParseExpectation(expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expectation.Match(args, kwargs) != expected {
+			t.Errorf("%s must match=%v", expression, expected)
+		}
+	}
+}
+
+func TestParseExpectationInvalid(t *testing.T) {
+	for _, expression := range []string{"args.0", "details.topic=foo"} {
+		if _, err := ParseExpectation(expression); err == nil {
+			t.Errorf("invalid expectation %s accepted", expression)
+		}
+	}
+}
@@ -25,133 +25,222 @@
 package core
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gammazero/nexus/v3/wamp"
 	"golang.org/x/crypto/ed25519"
-	"os/exec"
+	"io"
 	"strconv"
 	"strings"
 )
 
-func listToWampList(args []string) wamp.List {
-	var arguments wamp.List
+// parseValue converts a command line value to a WAMP value. A value may carry
+// an explicit type prefix: s: (string), i: (integer), f: (float), b: (boolean)
+// or j: (JSON), the rest of the value must then be of that type. s: can be used
+// to send a string that starts with a prefix as it is, e.g. "s:i:5". Other
+// values are guessed, unless rawStrings is set in which case they are sent as
+// strings.
+func parseValue(value string, rawStrings bool) (interface{}, error) {
+	if len(value) >= 2 && value[1] == ':' {
+		typed := value[2:]
+		switch value[0] {
+		case 's':
+			return typed, nil
+		case 'i':
+			number, err := strconv.Atoi(typed)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer %q", typed)
+			}
+			return number, nil
+		case 'f':
+			float, err := strconv.ParseFloat(typed, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid float %q", typed)
+			}
+			return float, nil
+		case 'b':
+			boolean, err := strconv.ParseBool(typed)
+			if err != nil {
+				return nil, fmt.Errorf("invalid boolean %q", typed)
+			}
+			return boolean, nil
+		case 'j':
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(typed), &decoded); err != nil {
+				return nil, fmt.Errorf("invalid JSON %q: %w", typed, err)
+			}
+			return decoded, nil
+		}
+	}
 
-	if args == nil {
-		return wamp.List{}
+	if rawStrings {
+		return value, nil
 	}
 
-	for _, value := range args {
+	var decoded interface{}
+	if value == "null" {
+		return nil, nil
+	} else if number, errNumber := strconv.Atoi(value); errNumber == nil {
+		return number, nil
+	} else if float, errFloat := strconv.ParseFloat(value, 64); errFloat == nil {
+		return float, nil
+	} else if boolean, errBoolean := strconv.ParseBool(value); errBoolean == nil {
+		return boolean, nil
+	} else if strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
+		if errJson := json.Unmarshal([]byte(value), &decoded); errJson == nil {
+			return decoded, nil
+		}
+	}
+	return value, nil
+}
+
+func listToWampList(args []string, rawStrings bool) (wamp.List, error) {
+	arguments := wamp.List{}
 
-		var mapJson map[string]interface{}
-		var mapList []map[string]interface{}
-
-		if number, errNumber := strconv.Atoi(value); errNumber == nil {
-			arguments = append(arguments, number)
-		} else if float, errFloat := strconv.ParseFloat(value, 64); errFloat == nil {
-			arguments = append(arguments, float)
-		} else if boolean, errBoolean := strconv.ParseBool(value); errBoolean == nil {
-			arguments = append(arguments, boolean)
-		} else if errJson := json.Unmarshal([]byte(value), &mapJson); errJson == nil {
-			arguments = append(arguments, mapJson)
-		} else if errList := json.Unmarshal([]byte(value), &mapList); errList == nil {
-			arguments = append(arguments, mapList)
-		} else {
-			arguments = append(arguments, value)
+	for _, value := range args {
+		argument, err := parseValue(value, rawStrings)
+		if err != nil {
+			return nil, err
 		}
+		arguments = append(arguments, argument)
 	}
 
-	return arguments
+	return arguments, nil
 }
 
-func dictToWampDict(kwargs map[string]string) wamp.Dict {
+func dictToWampDict(kwargs map[string]string, rawStrings bool) (wamp.Dict, error) {
 	var keywordArguments wamp.Dict = make(map[string]interface{})
 
 	for key, value := range kwargs {
-
-		var mapJson map[string]interface{}
-		var mapList []map[string]interface{}
-
-		if number, errNumber := strconv.Atoi(value); errNumber == nil {
-			keywordArguments[key] = number
-		} else if float, errFloat := strconv.ParseFloat(value, 64); errFloat == nil {
-			keywordArguments[key] = float
-		} else if boolean, errBoolean := strconv.ParseBool(value); errBoolean == nil {
-			keywordArguments[key] = boolean
-		} else if errJson := json.Unmarshal([]byte(value), &mapJson); errJson == nil {
-			keywordArguments[key] = mapJson
-		} else if errList := json.Unmarshal([]byte(value), &mapList); errList == nil {
-			keywordArguments[key] = mapList
-		} else {
-			keywordArguments[key] = value
+		argument, err := parseValue(value, rawStrings)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
 		}
+		keywordArguments[key] = argument
 	}
-	return keywordArguments
+	return keywordArguments, nil
 }
 
-func argsKWArgs(args wamp.List, kwArgs wamp.Dict, details wamp.Dict) {
-	if details != nil {
-		logger.Println(details)
-	}
+// payload holds the converted arguments, keyword arguments and options of a
+// call or publish.
+type payload struct {
+	args    wamp.List
+	kwargs  wamp.Dict
+	options wamp.Dict
+}
 
-	if len(args) != 0 {
-		fmt.Println("args:")
-		jsonString, err := json.MarshalIndent(args, "", "    ")
-		if err != nil {
-			logger.Fatal(err)
-		}
-		fmt.Println(string(jsonString))
+func newPayload(args []string, kwargs map[string]string, options map[string]string,
+	rawStrings bool) (*payload, error) {
+
+	arguments, err := listToWampList(args, rawStrings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argument: %w", err)
 	}
 
-	if len(kwArgs) != 0 {
-		fmt.Println("kwargs:")
-		jsonString, err := json.MarshalIndent(kwArgs, "", "    ")
-		if err != nil {
-			logger.Fatal(err)
-		}
-		fmt.Println(string(jsonString))
+	keywordArguments, err := dictToWampDict(kwargs, rawStrings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyword argument %w", err)
 	}
 
-	if len(args) == 0 && len(kwArgs) == 0 {
-		fmt.Println("args: []")
-		fmt.Println("kwargs: {}")
+	wampOptions, err := dictToWampDict(options, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid option %w", err)
 	}
-}
 
-func progressArgsKWArgs(args wamp.List, kwArgs wamp.Dict) {
+	return &payload{args: arguments, kwargs: keywordArguments, options: wampOptions}, nil
+}
 
-	if len(args) != 0 {
-		fmt.Print("args: ", args, "  ")
+// extend appends already typed arguments and adds keyword arguments that are
+// not given yet.
+func (p *payload) extend(args wamp.List, kwargs wamp.Dict) {
+	p.args = append(p.args, args...)
+	for key, value := range kwargs {
+		if _, ok := p.kwargs[key]; !ok {
+			p.kwargs[key] = value
+		}
 	}
+}
 
-	if len(kwArgs) != 0 {
-		fmt.Print("kwargs: ")
-		bs, _ := json.Marshal(kwArgs)
-		fmt.Print(string(bs))
-	}
+// with returns a payload with the same options but other arguments.
+func (p *payload) with(args wamp.List, kwargs wamp.Dict) *payload {
+	return &payload{args: args, kwargs: kwargs, options: p.options}
+}
 
-	if len(args) == 0 && len(kwArgs) == 0 {
-		fmt.Print("args: []", "kwargs: {}")
+// copyOptions returns a copy of the options, as the client modifies the
+// options it is given.
+func (p *payload) copyOptions() wamp.Dict {
+	options := make(wamp.Dict, len(p.options))
+	for key, value := range p.options {
+		options[key] = value
 	}
-
-	fmt.Println()
+	return options
 }
 
-func shellOut(command string) (error, string, string) {
+// shellOut runs command with bash and returns its output. When ctx is done the
+// command and everything it started are stopped.
+func shellOut(ctx context.Context, command string, stdin []byte, env []string) (error, string, string) {
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
-	var cmd *exec.Cmd
-	cmd = exec.Command("bash", "-c", command)
+	cmd := shellCommand(command)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Env = env
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err := cmd.Run()
+	if err := cmd.Start(); err != nil {
+		return err, "", ""
+	}
+
+	stop := watchProcess(ctx, cmd)
+	err := cmd.Wait()
+	stop()
 	return err, stdout.String(), stderr.String()
 }
 
-func getKeyPair(privateKeyKex string) (ed25519.PublicKey, ed25519.PrivateKey) {
-	privateKeyRaw, _ := hex.DecodeString(privateKeyKex)
+// shellOutLines is shellOut calling onLine with every line of stdout while the
+// command runs instead of collecting it.
+func shellOutLines(ctx context.Context, command string, stdin []byte, env []string,
+	onLine func(line string)) (error, string) {
+	var stderr bytes.Buffer
+	cmd := shellCommand(command)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Env = env
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err, ""
+	}
+	if err = cmd.Start(); err != nil {
+		return err, ""
+	}
+	stop := watchProcess(ctx, cmd)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		onLine(scanner.Text())
+	}
+	// drain what is left after an over-long line so that the command can exit
+	_, _ = io.Copy(io.Discard, stdout)
+
+	err = cmd.Wait()
+	stop()
+	if err == nil {
+		err = scanner.Err()
+	}
+	return err, stderr.String()
+}
+
+func getKeyPair(privateKeyKex string) (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	privateKeyRaw, err := hex.DecodeString(privateKeyKex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid private key: %w", err)
+	}
 	var privateKey ed25519.PrivateKey
 
 	if len(privateKeyRaw) == 32 {
@@ -159,19 +248,20 @@ func getKeyPair(privateKeyKex string) (ed25519.PublicKey, ed25519.PrivateKey) {
 	} else if len(privateKeyRaw) == 64 {
 		privateKey = ed25519.NewKeyFromSeed(privateKeyRaw[:32])
 	} else {
-		logger.Fatal("Invalid private key. Cryptosign private key must be either 32 or 64 characters long")
+		return nil, nil, errors.New("invalid private key. Cryptosign private key must be either 32 or 64 " +
+			"characters long")
 	}
 
 	publicKey := privateKey.Public().(ed25519.PublicKey)
 
-	return publicKey, privateKey
+	return publicKey, privateKey, nil
 }
 
 func sanitizeURL(url string) string {
-	if strings.HasPrefix(url, "rs") {
-		return "tcp" + strings.TrimPrefix(url, "rs")
-	} else if strings.HasPrefix(url, "rss") {
-		return "tcp" + strings.TrimPrefix(url, "rss")
+	if strings.HasPrefix(url, "rss://") {
+		return "tcps://" + strings.TrimPrefix(url, "rss://")
+	} else if strings.HasPrefix(url, "rs://") {
+		return "tcp://" + strings.TrimPrefix(url, "rs://")
 	}
 	return url
 }
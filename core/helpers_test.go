@@ -25,8 +25,11 @@
 package core
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
 )
 
 const (
@@ -34,7 +37,10 @@ const (
 )
 
 func TestPrivateHexToKeyPair(t *testing.T) {
-	publicKey, privateKey := getKeyPair(privateKeyHex)
+	publicKey, privateKey, err := getKeyPair(privateKeyHex)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if publicKey == nil {
 		t.Errorf("public key is nil")
@@ -45,9 +51,90 @@ func TestPrivateHexToKeyPair(t *testing.T) {
 	}
 }
 
+func TestInvalidPrivateKey(t *testing.T) {
+	if _, _, err := getKeyPair("b99067e6"); err == nil {
+		t.Error("short private key must fail")
+	}
+
+	if _, _, err := getKeyPair("not hex"); err == nil {
+		t.Error("non hex private key must fail")
+	}
+}
+
 func TestUrlSanitization(t *testing.T) {
 	url := sanitizeURL("rs://localhost:8080/")
-	if !strings.HasPrefix(url, "tcp") {
+	if !strings.HasPrefix(url, "tcp://") {
 		t.Error("url sanitization failed")
 	}
+
+	url = sanitizeURL("rss://localhost:8080/")
+	if !strings.HasPrefix(url, "tcps://") {
+		t.Error("secure url sanitization failed")
+	}
+
+	url = sanitizeURL("wss://localhost:8080/ws")
+	if url != "wss://localhost:8080/ws" {
+		t.Error("websocket url must not be changed")
+	}
+}
+
+func TestListToWampList(t *testing.T) {
+	args, err := listToWampList([]string{"123", "1.5", "true", "null", "[1,2]", `{"a":1}`, "hello"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := wamp.List{123, 1.5, true, nil, []interface{}{1.0, 2.0}, map[string]interface{}{"a": 1.0}, "hello"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("wrong conversion, expected=%v, got=%v", expected, args)
+	}
+}
+
+func TestTypedValues(t *testing.T) {
+	args, err := listToWampList([]string{"s:123", "s:true", "i:5", "f:1", "b:false", "j:[1,\"a\"]", "s:s:x"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := wamp.List{"123", "true", 5, 1.0, false, []interface{}{1.0, "a"}, "s:x"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("wrong conversion, expected=%v, got=%v", expected, args)
+	}
+}
+
+func TestInvalidTypedValue(t *testing.T) {
+	for _, value := range []string{"i:abc", "f:abc", "b:maybe", "j:{"} {
+		if _, err := parseValue(value, false); err == nil {
+			t.Errorf("%s must fail", value)
+		}
+	}
+
+	if _, err := dictToWampDict(map[string]string{"key": "i:1.5"}, false); err == nil {
+		t.Error("invalid keyword argument must fail")
+	}
+}
+
+func TestEscapedTypePrefix(t *testing.T) {
+	kwargs, err := dictToWampDict(map[string]string{"key": "s:i:5", "other": "x:1"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kwargs["key"] != "i:5" {
+		t.Errorf("s: must escape a type prefix, got %v", kwargs["key"])
+	}
+	if kwargs["other"] != "x:1" {
+		t.Errorf("unknown prefix must be sent as it is, got %v", kwargs["other"])
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	kwargs, err := dictToWampDict(map[string]string{"string": "123", "typed": "i:123", "null": "null"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := wamp.Dict{"string": "123", "typed": 123, "null": "null"}
+	if !reflect.DeepEqual(kwargs, expected) {
+		t.Errorf("wrong conversion, expected=%v, got=%v", expected, kwargs)
+	}
 }
@@ -0,0 +1,191 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// invocation is what a registered command learns about the call it serves.
+type invocation struct {
+	Procedure string    `json:"procedure"`
+	Args      wamp.List `json:"args"`
+	Kwargs    wamp.Dict `json:"kwargs"`
+	Details   wamp.Dict `json:"details"`
+}
+
+// newInvocation returns the invocation of procedure. Pattern registrations
+// carry the concrete procedure in the details, which is used instead.
+func newInvocation(procedure string, inv *wamp.Invocation) invocation {
+	if uri, ok := wamp.AsURI(inv.Details["procedure"]); ok {
+		procedure = string(uri)
+	}
+	data := invocation{Procedure: procedure, Args: inv.Arguments, Kwargs: inv.ArgumentsKw, Details: inv.Details}
+	if data.Args == nil {
+		data.Args = wamp.List{}
+	}
+	if data.Kwargs == nil {
+		data.Kwargs = wamp.Dict{}
+	}
+	if data.Details == nil {
+		data.Details = wamp.Dict{}
+	}
+	return data
+}
+
+// stdin is the invocation as a JSON document.
+func (i invocation) stdin() ([]byte, error) {
+	data, err := json.Marshal(i)
+	if err != nil {
+		return nil, &SerializationError{Err: err}
+	}
+	return append(data, '\n'), nil
+}
+
+// env returns the environment of a command: wick's own environment plus the
+// procedure, the args, kwargs and details as JSON and, if disclosed, the caller.
+func (i invocation) env() ([]string, error) {
+	env := os.Environ()
+	env = append(env, "WICK_PROCEDURE="+i.Procedure)
+
+	for name, value := range map[string]interface{}{"WICK_ARGS": i.Args, "WICK_KWARGS": i.Kwargs,
+		"WICK_DETAILS": i.Details} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, &SerializationError{Err: err}
+		}
+		env = append(env, name+"="+string(data))
+	}
+
+	for name, key := range map[string]string{"WICK_CALLER": "caller", "WICK_CALLER_AUTHID": "caller_authid",
+		"WICK_CALLER_AUTHROLE": "caller_authrole"} {
+		if value, ok := i.Details[key]; ok {
+			env = append(env, fmt.Sprintf("%s=%v", name, value))
+		}
+	}
+	return env, nil
+}
+
+// value returns the value at a path such as procedure, args.0 or
+// kwargs.user.name.
+func (i invocation) value(path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+
+	var value interface{}
+	switch keys[0] {
+	case "procedure":
+		value = i.Procedure
+	case "args":
+		value = i.Args
+	case "kwargs":
+		value = i.Kwargs
+	case "details":
+		value = i.Details
+	default:
+		return nil, false
+	}
+
+	for _, key := range keys[1:] {
+		var ok bool
+		if value, ok = lookup(value, key); !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][\w.]*)\s*\}\}`)
+
+// expand replaces placeholders such as {{args.0}} or {{kwargs.name}} in text
+// with the formatted value at their path. Missing values format as nil.
+func (i invocation) expand(text string, format func(value interface{}) string) string {
+	return placeholder.ReplaceAllStringFunc(text, func(match string) string {
+		value, _ := i.value(placeholder.FindStringSubmatch(match)[1])
+		return format(value)
+	})
+}
+
+// render replaces placeholders in the strings of value, which may be nested
+// in lists and objects. A string that is just one placeholder is replaced with
+// the value at its path, keeping its type.
+func (i invocation) render(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if match := placeholder.FindStringSubmatch(v); match != nil && match[0] == v {
+			result, _ := i.value(match[1])
+			return result
+		}
+		return i.expand(v, plainText)
+	case []interface{}:
+		return i.renderList(v)
+	case wamp.List:
+		return i.renderList(v)
+	case map[string]interface{}:
+		return i.renderDict(v)
+	case wamp.Dict:
+		return i.renderDict(v)
+	}
+	return value
+}
+
+func (i invocation) renderList(list []interface{}) wamp.List {
+	rendered := make(wamp.List, len(list))
+	for index, item := range list {
+		rendered[index] = i.render(item)
+	}
+	return rendered
+}
+
+func (i invocation) renderDict(dict map[string]interface{}) wamp.Dict {
+	rendered := make(wamp.Dict, len(dict))
+	for key, item := range dict {
+		rendered[key] = i.render(item)
+	}
+	return rendered
+}
+
+// plainText formats a value for use in text. Strings are used as they are,
+// other values as JSON.
+func plainText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// shellQuote formats a value as a single shell word, see plainText.
+func shellQuote(value interface{}) string {
+	return "'" + strings.ReplaceAll(plainText(value), "'", `'\''`) + "'"
+}
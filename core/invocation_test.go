@@ -0,0 +1,101 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestInvocationEnv(t *testing.T) {
+	data := newInvocation("foo.bar", &wamp.Invocation{Arguments: wamp.List{1, "a"},
+		Details: wamp.Dict{"caller_authid": "john"}})
+
+	env, err := data.env()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joined := strings.Join(env, "\n")
+	for _, variable := range []string{"WICK_PROCEDURE=foo.bar", `WICK_ARGS=[1,"a"]`, "WICK_KWARGS={}",
+		"WICK_CALLER_AUTHID=john"} {
+		if !strings.Contains(joined, variable) {
+			t.Errorf("missing %s", variable)
+		}
+	}
+}
+
+func TestInvocationExpand(t *testing.T) {
+	data := newInvocation("foo.bar", &wamp.Invocation{Arguments: wamp.List{"it's"},
+		ArgumentsKw: wamp.Dict{"n": map[string]interface{}{"list": []interface{}{1, 2}}}})
+
+	expanded := data.expand("echo {{args.0}} {{ kwargs.n.list }} {{args.5}} {{procedure}}", shellQuote)
+	if expanded != `echo 'it'\''s' '[1,2]' '' 'foo.bar'` {
+		t.Errorf("unexpected expansion %s", expanded)
+	}
+}
+
+func TestInvocationRender(t *testing.T) {
+	data := newInvocation("foo.bar", &wamp.Invocation{Arguments: wamp.List{2, "john"}})
+
+	rendered := data.render(wamp.Dict{"count": "{{args.0}}", "greeting": "hello {{args.1}}",
+		"list": []interface{}{"{{args}}", true}})
+	expected := wamp.Dict{"count": 2, "greeting": "hello john", "list": wamp.List{wamp.List{2, "john"}, true}}
+	if fmt.Sprint(rendered) != fmt.Sprint(expected) {
+		t.Errorf("got %v, expected %v", rendered, expected)
+	}
+}
+
+func TestRegisterPassesInvocationToCommand(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	callee := connectTestSession(t, url)
+	caller := connectTestSession(t, url)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Register(ctx, callee, NewOutput(&bytes.Buffer{}, FormatText), RegisterParams{Procedure: "foo.cmd",
+		Command: `printf '%s|%s|%s|' "$WICK_PROCEDURE" "$WICK_KWARGS" {{args.1}}; cat`, Template: true})
+
+	// give the registration time to be established
+	time.Sleep(100 * time.Millisecond)
+
+	var buffer bytes.Buffer
+	err := Call(context.Background(), caller, NewOutput(&buffer, FormatRaw), CallParams{Procedure: "foo.cmd",
+		Args: []string{"1", "two words"}, Kwargs: map[string]string{"k": "v"}, Repeat: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `foo.cmd|{"k":"v"}|two words|{"procedure":"foo.cmd","args":[1,"two words"],"kwargs":{"k":"v"}`
+	if !strings.HasPrefix(buffer.String(), expected) {
+		t.Errorf("unexpected result %q", buffer.String())
+	}
+}
@@ -0,0 +1,243 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// LatencyParams configures MeasureLatency.
+type LatencyParams struct {
+	Topic string
+	// Repeat is the number of events to publish one after the other, they must
+	// leave in the order of their sequence numbers.
+	Repeat int
+	Load
+	// Wait is how long to wait for events still in flight after the last
+	// publish before counting them as lost.
+	Wait time.Duration
+}
+
+// LatencyReport summarizes a latency measurement. The embedded Report holds
+// the delivery latency of the received events.
+type LatencyReport struct {
+	Report
+	Published  int `json:"published"`
+	Received   int `json:"received"`
+	Lost       int `json:"lost"`
+	OutOfOrder int `json:"out_of_order"`
+	Duplicates int `json:"duplicates"`
+}
+
+// latencyProbe tracks the events of a latency measurement.
+type latencyProbe struct {
+	// sendMu keeps concurrent publishes in the order of their sequence numbers.
+	sendMu     sync.Mutex
+	mu         sync.Mutex
+	nextSeq    int64
+	sent       map[int64]time.Time
+	warmUp     map[int64]bool
+	received   map[int64]bool
+	maxSeq     int64
+	outOfOrder int
+	duplicates int
+	stats      *Stats
+}
+
+func (p *latencyProbe) send(warmUp bool) (int64, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextSeq++
+	now := time.Now()
+	p.sent[p.nextSeq] = now
+	if warmUp {
+		p.warmUp[p.nextSeq] = true
+	}
+	return p.nextSeq, now
+}
+
+// publish numbers an event and publishes it with fn. Numbering and publishing
+// happen under one lock, so that events published at a rate leave in the order
+// of their numbers; a publish without acknowledgement only queues the event.
+func (p *latencyProbe) publish(warmUp bool, fn func(seq int64, sent time.Time) error) error {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+
+	seq, sent := p.send(warmUp)
+	err := fn(seq, sent)
+	if err != nil {
+		p.unsent(seq)
+	}
+	return err
+}
+
+// unsent forgets an event that could not be published.
+func (p *latencyProbe) unsent(seq int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sent, seq)
+	delete(p.warmUp, seq)
+}
+
+func (p *latencyProbe) receive(event *wamp.Event) {
+	now := time.Now()
+
+	if len(event.Arguments) == 0 {
+		return
+	}
+	seq, ok := wamp.AsInt64(event.Arguments[0])
+	if !ok {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sent, ok := p.sent[seq]
+	if !ok {
+		return // not ours, e.g. from an earlier run
+	}
+
+	if p.received[seq] {
+		p.duplicates++
+		return
+	}
+	p.received[seq] = true
+
+	if seq < p.maxSeq {
+		p.outOfOrder++
+	} else {
+		p.maxSeq = seq
+	}
+
+	if !p.warmUp[seq] {
+		p.stats.add(now.Sub(sent), nil)
+		// the measurement lasts until the last event arrived
+		p.stats.extend(now)
+	}
+}
+
+func (p *latencyProbe) pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sent) - len(p.received)
+}
+
+func (p *latencyProbe) report() LatencyReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	report := LatencyReport{
+		Report:     p.stats.Report(),
+		Published:  len(p.sent) - len(p.warmUp),
+		OutOfOrder: p.outOfOrder,
+		Duplicates: p.duplicates,
+	}
+	for seq := range p.received {
+		if !p.warmUp[seq] {
+			report.Received++
+		}
+	}
+	report.Lost = report.Published - report.Received
+	return report
+}
+
+// MeasureLatency publishes sequence numbered, timestamped events with
+// publisher and measures how long they take to arrive at subscriber, which
+// may be the same session. The report is written to out, also when ctx is done
+// or a publish fails before all events were published.
+func MeasureLatency(ctx context.Context, subscriber *client.Client, publisher *client.Client, out *Output,
+	params LatencyParams) (LatencyReport, error) {
+
+	if err := params.Load.validate(params.Repeat, 0); err != nil {
+		return LatencyReport{}, err
+	}
+
+	probe := &latencyProbe{
+		sent:     map[int64]time.Time{},
+		warmUp:   map[int64]bool{},
+		received: map[int64]bool{},
+		stats:    NewStats(),
+	}
+
+	if err := subscriber.Subscribe(params.Topic, probe.receive, nil); err != nil {
+		return LatencyReport{}, err
+	}
+	defer func() {
+		if err := subscriber.Unsubscribe(params.Topic); err != nil {
+			logger.Println("Failed to unsubscribe:", err)
+		}
+	}()
+	logger.Printf("Subscribed to topic '%s'\n", params.Topic)
+
+	// the publisher must not be excluded when it is the subscriber too
+	options := wamp.Dict{wamp.OptExcludeMe: false}
+	_, err := params.Load.run(ctx, params.Repeat, 1, probe.stats,
+		func(ctx context.Context, req request) error {
+			return probe.publish(req.warmUp, func(seq int64, sent time.Time) error {
+				return publisher.Publish(params.Topic, options, wamp.List{seq, sent.UnixNano()}, nil)
+			})
+		})
+
+	deadline := time.Now().Add(params.Wait)
+	for probe.pending() > 0 && time.Now().Before(deadline) {
+		if sleep(ctx, 10*time.Millisecond) != nil {
+			break
+		}
+	}
+
+	report := probe.report()
+	if writeErr := out.latencyReport(report); err == nil {
+		err = writeErr
+	}
+	return report, err
+}
+
+func (o *Output) latencyReport(report LatencyReport) error {
+	if o.format != FormatText {
+		return o.write(report)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "published:  %d\n", report.Published)
+	fmt.Fprintf(&b, "received:   %d (%d lost, %d out of order, %d duplicates)\n", report.Received,
+		report.Lost, report.OutOfOrder, report.Duplicates)
+	if err := report.Report.WriteText(&b); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err := b.WriteTo(o.w)
+	return err
+}
@@ -0,0 +1,119 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestMeasureLatency(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+
+	for _, separate := range []bool{false, true} {
+		subscriber := connectTestSession(t, url)
+		publisher := subscriber
+		if separate {
+			publisher = connectTestSession(t, url)
+		}
+
+		var buffer bytes.Buffer
+		report, err := MeasureLatency(context.Background(), subscriber, publisher, NewOutput(&buffer, FormatText),
+			LatencyParams{Topic: "wick.latency", Repeat: 20, Wait: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Published != 20 || report.Received != 20 || report.Lost != 0 || report.Succeeded != 20 {
+			t.Errorf("unexpected report %+v", report)
+		}
+		if !strings.Contains(buffer.String(), "received:   20 (0 lost") {
+			t.Errorf("unexpected output %q", buffer.String())
+		}
+	}
+}
+
+func TestLatencyProbeOrdering(t *testing.T) {
+	probe := &latencyProbe{sent: map[int64]time.Time{}, warmUp: map[int64]bool{}, received: map[int64]bool{},
+		stats: NewStats()}
+	for i := 0; i < 4; i++ {
+		probe.send(false)
+	}
+
+	for _, seq := range []int64{1, 3, 2, 3} {
+		probe.receive(&wamp.Event{Arguments: wamp.List{seq, 0}})
+	}
+
+	report := probe.report()
+	if report.Received != 3 || report.Lost != 1 || report.OutOfOrder != 1 || report.Duplicates != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestMeasureLatencyRate(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	session := connectTestSession(t, url)
+
+	report, err := MeasureLatency(context.Background(), session, session, NewOutput(&bytes.Buffer{}, FormatText),
+		LatencyParams{Topic: "wick.latency", Repeat: 500, Load: Load{Rate: 5000}, Wait: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Received != 500 || report.OutOfOrder != 0 {
+		t.Errorf("events published at a rate must arrive in order %+v", report)
+	}
+	if report.DurationMs < report.MaxMs {
+		t.Errorf("duration %fms ends before the slowest event %fms", report.DurationMs, report.MaxMs)
+	}
+}
+
+func TestMeasureLatencyCanceled(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	session := connectTestSession(t, url)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	var buffer bytes.Buffer
+	report, err := MeasureLatency(ctx, session, session, NewOutput(&buffer, FormatText),
+		LatencyParams{Topic: "wick.latency", Load: Load{Rate: 100, Duration: time.Minute}, Wait: time.Second})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+
+	if report.Published == 0 || report.Received == 0 {
+		t.Errorf("partial report expected, got %+v", report)
+	}
+	if !strings.Contains(buffer.String(), "published:") {
+		t.Errorf("partial report not written %q", buffer.String())
+	}
+}
@@ -0,0 +1,124 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// ErrQueueFull is returned to callers when all invocation slots are busy and
+// the wait queue is full.
+const ErrQueueFull = wamp.URI("wick.error.queue_full")
+
+var errQueueFull = errors.New("invocation queue is full")
+
+// invocationLimiter bounds how many invocations are handled at once. Further
+// invocations wait in a first in, first out queue of bounded size, if any.
+type invocationLimiter struct {
+	mu             sync.Mutex
+	maxConcurrency int
+	maxQueue       int
+	inFlight       int
+	// waiters are closed in turn when a slot is handed to them.
+	waiters []chan struct{}
+}
+
+// newInvocationLimiter returns a limiter running at most maxConcurrency
+// invocations at a time and queueing at most maxQueue. Zero means no limit.
+func newInvocationLimiter(maxConcurrency int, maxQueue int) *invocationLimiter {
+	return &invocationLimiter{maxConcurrency: maxConcurrency, maxQueue: maxQueue}
+}
+
+// acquire waits for a free slot and returns the function releasing it again.
+// It fails with errQueueFull if the queue is full, or with the context error
+// if ctx is done while waiting.
+func (l *invocationLimiter) acquire(ctx context.Context) (func(), error) {
+	l.mu.Lock()
+	// Nobody may overtake invocations that are already waiting.
+	if l.maxConcurrency <= 0 || (l.inFlight < l.maxConcurrency && len(l.waiters) == 0) {
+		l.inFlight++
+		l.log("started invocation")
+		l.mu.Unlock()
+		return l.release, nil
+	}
+
+	if l.maxQueue > 0 && len(l.waiters) >= l.maxQueue {
+		l.log("rejected invocation")
+		l.mu.Unlock()
+		return nil, errQueueFull
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.log("queued invocation")
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return l.release, nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	for i, waiter := range l.waiters {
+		if waiter == ready {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			l.log("canceled queued invocation")
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	l.mu.Unlock()
+	// The slot was handed over while ctx was done, pass it on.
+	l.release()
+	return nil, ctx.Err()
+}
+
+// release hands the slot to the longest waiting invocation, if any.
+func (l *invocationLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.waiters) == 0 {
+		l.inFlight--
+		l.log("finished invocation")
+		return
+	}
+	next := l.waiters[0]
+	l.waiters = l.waiters[1:]
+	close(next)
+	l.log("finished invocation, started queued one")
+}
+
+// log logs the in-flight and queued counts when invocations are limited, l.mu
+// must be held.
+func (l *invocationLimiter) log(event string) {
+	if l.maxConcurrency > 0 {
+		logger.Printf("%s, %d in flight, %d queued\n", event, l.inFlight, len(l.waiters))
+	}
+}
@@ -0,0 +1,132 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInvocationLimiter(t *testing.T) {
+	limiter := newInvocationLimiter(1, 1)
+
+	release, err := limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		queuedRelease, err := limiter.acquire(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- queuedRelease
+	}()
+
+	// give the second invocation time to be queued
+	time.Sleep(50 * time.Millisecond)
+	if _, err = limiter.acquire(context.Background()); !errors.Is(err, errQueueFull) {
+		t.Errorf("expected full queue, got %v", err)
+	}
+
+	select {
+	case <-acquired:
+		t.Fatal("queued invocation must wait for a free slot")
+	default:
+	}
+	release()
+	(<-acquired)()
+
+	release, err = limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = limiter.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline, got %v", err)
+	}
+	release()
+
+	if limiter.inFlight != 0 || len(limiter.waiters) != 0 {
+		t.Errorf("unbalanced counts %d in flight, %d queued", limiter.inFlight, len(limiter.waiters))
+	}
+}
+
+func TestInvocationLimiterOrder(t *testing.T) {
+	limiter := newInvocationLimiter(1, 0)
+
+	release, err := limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release, err := limiter.acquire(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			release()
+		}(i)
+
+		// wait until the invocation is queued before starting the next one
+		for queued := 0; queued != i+1; {
+			time.Sleep(time.Millisecond)
+			limiter.mu.Lock()
+			queued = len(limiter.waiters)
+			limiter.mu.Unlock()
+		}
+	}
+
+	release()
+	wg.Wait()
+	if fmt.Sprint(order) != "[0 1 2 3 4]" {
+		t.Errorf("queued invocations not served in order %v", order)
+	}
+}
+
+func TestRegisterQueueWithoutConcurrency(t *testing.T) {
+	err := Register(context.Background(), nil, NewOutput(&bytes.Buffer{}, FormatText), RegisterParams{
+		Procedure: "foo.bar", Command: "echo", MaxQueue: 10})
+	if err == nil {
+		t.Error("a queue limit without a concurrency limit must fail")
+	}
+}
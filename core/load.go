@@ -0,0 +1,179 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Load configures rate controlled and duration based runs of Call and
+// Publish.
+type Load struct {
+	// Rate, if positive, starts this many requests per second on a fixed
+	// schedule, however long earlier requests take. Latency is measured from
+	// the scheduled start so that a slow router cannot hide queueing delays.
+	// Concurrency does not apply.
+	Rate float64
+	// Duration, if positive, runs for that long instead of Repeat times.
+	Duration time.Duration
+	// WarmUp runs requests for that long before the measured run; they are not
+	// counted in the stats.
+	WarmUp time.Duration
+	// RampUp raises the rate linearly from zero to Rate.
+	RampUp time.Duration
+}
+
+func (l Load) validate(repeat int, delay time.Duration) error {
+	switch {
+	case l.Duration == 0 && repeat < 1:
+		return errors.New("repeat count must be greater than zero")
+	case l.Rate < 0:
+		return errors.New("rate must not be negative")
+	case l.Duration < 0 || l.WarmUp < 0 || l.RampUp < 0:
+		return errors.New("duration, warm-up and ramp-up must not be negative")
+	case l.RampUp > 0 && l.Rate == 0:
+		return errors.New("ramp-up requires a rate")
+	case l.Rate > 0 && delay > 0:
+		return errors.New("delay cannot be combined with a rate")
+	}
+	return nil
+}
+
+// request is one scheduled call or publish.
+type request struct {
+	// intended is when the request was scheduled to start, zero if it starts
+	// as soon as possible.
+	intended time.Time
+	// warmUp requests are not counted in the stats.
+	warmUp bool
+}
+
+// start returns when the latency of the request is measured from.
+func (r request) start() time.Time {
+	if r.intended.IsZero() {
+		return time.Now()
+	}
+	return r.intended
+}
+
+// offset returns when the i-th request, counting from zero, is due. While
+// ramping up the rate grows linearly, so the number of requests due after t is
+// rate*t²/(2*rampUp).
+func (l Load) offset(i int) time.Duration {
+	rampRequests := l.Rate * l.RampUp.Seconds() / 2
+	if float64(i) < rampRequests {
+		return time.Duration(math.Sqrt(2*l.RampUp.Seconds()*float64(i)/l.Rate) * float64(time.Second))
+	}
+	return l.RampUp + time.Duration((float64(i)-rampRequests)/l.Rate*float64(time.Second))
+}
+
+// run starts fn for every request of the run, repeat times or for the
+// configured duration, and waits for all of them. With a rate, requests start
+// on schedule, otherwise at most concurrency run at a time. It stops at the
+// first error and returns it together with the number of measured requests.
+func (l Load) run(ctx context.Context, repeat int, concurrency int, stats *Stats,
+	fn func(ctx context.Context, req request) error) (int, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if l.Rate > 0 {
+		concurrency = 0
+	} else if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	concurrentGoroutines := make(chan struct{}, concurrency)
+
+	start := time.Now()
+	measureStart := start.Add(l.WarmUp)
+	stats.begin(measureStart)
+
+	measured := 0
+	for i := 0; ctx.Err() == nil; i++ {
+		req := request{}
+		if l.Rate > 0 {
+			req.intended = start.Add(l.offset(i))
+		} else {
+			select {
+			case concurrentGoroutines <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		now := req.intended
+		if now.IsZero() {
+			now = time.Now()
+		}
+		if (l.Duration > 0 && !now.Before(measureStart.Add(l.Duration))) ||
+			(l.Duration == 0 && measured >= repeat && !now.Before(measureStart)) {
+			if concurrency > 0 {
+				<-concurrentGoroutines
+			}
+			break
+		}
+
+		if err := sleep(ctx, time.Until(req.intended)); err != nil {
+			break
+		}
+
+		req.warmUp = now.Before(measureStart)
+		if !req.warmUp {
+			measured++
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(ctx, req); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+			if concurrency > 0 {
+				<-concurrentGoroutines
+			}
+		}()
+	}
+
+	wg.Wait()
+	stats.finish()
+
+	if firstErr != nil {
+		return measured, firstErr
+	}
+	return measured, ctx.Err()
+}
@@ -0,0 +1,121 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadOffset(t *testing.T) {
+	load := Load{Rate: 100}
+	if offset := load.offset(50); offset != 500*time.Millisecond {
+		t.Errorf("wrong offset without ramp-up %s", offset)
+	}
+
+	// 100/s reached after 2s, 100 requests are due during the ramp-up
+	load.RampUp = 2 * time.Second
+	if offset := load.offset(25); offset != time.Second {
+		t.Errorf("wrong offset during ramp-up %s", offset)
+	}
+	if offset := load.offset(150); offset != 2500*time.Millisecond {
+		t.Errorf("wrong offset after ramp-up %s", offset)
+	}
+}
+
+func TestLoadValidate(t *testing.T) {
+	invalid := []struct {
+		load   Load
+		repeat int
+		delay  time.Duration
+	}{
+		{Load{}, 0, 0},
+		{Load{Rate: -1}, 1, 0},
+		{Load{RampUp: time.Second}, 1, 0},
+		{Load{Rate: 10}, 1, time.Millisecond},
+	}
+
+	for _, c := range invalid {
+		if err := c.load.validate(c.repeat, c.delay); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+
+	if err := (Load{Duration: time.Second}).validate(0, 0); err != nil {
+		t.Errorf("duration must replace repeat, got %v", err)
+	}
+}
+
+func TestLoadRunRate(t *testing.T) {
+	var started int32
+	stats := NewStats()
+	load := Load{Rate: 200, Duration: 250 * time.Millisecond, WarmUp: 100 * time.Millisecond}
+
+	measured, err := load.run(context.Background(), 1, 1, stats, func(ctx context.Context, req request) error {
+		atomic.AddInt32(&started, 1)
+		if !req.warmUp {
+			stats.add(time.Since(req.start()), nil)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if measured != 50 || started != 70 {
+		t.Errorf("expected 50 measured of 70 requests, got %d of %d", measured, started)
+	}
+	if report := stats.Report(); report.Requests != 50 {
+		t.Errorf("warm-up requests must not be counted, got %d", report.Requests)
+	}
+}
+
+func TestLoadRunRepeat(t *testing.T) {
+	var running, maxRunning int32
+	measured, err := Load{}.run(context.Background(), 20, 4, nil, func(ctx context.Context, req request) error {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			previous := atomic.LoadInt32(&maxRunning)
+			if current <= previous || atomic.CompareAndSwapInt32(&maxRunning, previous, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if measured != 20 {
+		t.Errorf("expected 20 requests, got %d", measured)
+	}
+	if maxRunning > 4 {
+		t.Errorf("concurrency exceeded, %d requests in flight", maxRunning)
+	}
+}
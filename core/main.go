@@ -26,17 +26,17 @@ package core
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/sirupsen/logrus"
-	"os"
-	"os/signal"
+	"io"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gammazero/nexus/v3/client"
-	"github.com/gammazero/nexus/v3/transport/serialize"
 	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
 )
 
 var logger *logrus.Logger
@@ -45,283 +45,697 @@ func init() {
 	logger = logrus.New()
 }
 
-func connect(url string, cfg client.Config) *client.Client {
+// SetLogger replaces the logger used for diagnostics, which defaults to a
+// logrus logger writing to stderr.
+func SetLogger(l *logrus.Logger) {
+	logger = l
+}
 
-	url = sanitizeURL(url)
+// Topic is a topic to subscribe to and how it is matched.
+type Topic struct {
+	URI string
+	// Match is exact, prefix or wildcard. If empty the match option of
+	// SubscribeParams.Options applies, which defaults to exact.
+	Match string
+}
 
-	session, err := client.ConnectNet(context.Background(), url, cfg)
-	if err != nil {
-		logger.Fatal(err)
-	} else {
-		// FIXME: use a better logger and only print such messages in debug mode.
-		//logger.Println("Connected to ", baseUrl)
-	}
+// SubscribeParams configures Subscribe.
+type SubscribeParams struct {
+	Topics       []Topic
+	Options      map[string]string
+	PrintDetails bool
+	// Reconnect, if set, re-subscribes after the router connection is lost.
+	Reconnect *Reconnect
+	// Pool, if set, spreads the topics over its sessions instead of session.
+	Pool *Pool
+	// Count, if positive, stops after that many events meeting Expect.
+	Count int
+	// Timeout, if positive, stops after that long. It is an error if fewer
+	// than Count events arrived by then.
+	Timeout time.Duration
+	// Expect are conditions events must meet to be counted.
+	Expect []Expectation
+	// Record, if set, receives every event as a line of JSON with its time,
+	// topic, args, kwargs and details, for replaying with Publish.
+	Record io.Writer
+}
 
-	return session
+// eventTracker records the received events and counts those meeting the
+// expectations, closing done when enough have arrived. Events arriving after
+// that are dropped, so exactly the expected number is written.
+type eventTracker struct {
+	mu       sync.Mutex
+	count    int
+	target   int
+	expect   []Expectation
+	done     chan struct{}
+	recorder *recorder
 }
 
-func ConnectAnonymous(url string, realm string, serializer serialize.Serialization, authid string,
-	authrole string) *client.Client {
+// add writes and records event unless enough events have arrived already.
+// Handlers run concurrently, so the decision and the write happen under mu.
+func (t *eventTracker) add(topic string, event *wamp.Event, write func() error) {
+	matched := matchAll(t.expect, event.Arguments, event.ArgumentsKw)
 
-	cfg := getAnonymousAuthConfig(realm, serializer, authid, authrole)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.target > 0 && t.count >= t.target {
+		return
+	}
+
+	if err := write(); err != nil {
+		logger.Errorln(err)
+	}
+	if err := t.recorder.record(topic, event); err != nil {
+		logger.Errorln(err)
+	}
+
+	if !matched {
+		return
+	}
+	t.count++
+	if t.count == t.target {
+		close(t.done)
+	}
+}
 
-	return connect(url, cfg)
+func (t *eventTracker) received() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
 }
 
-func ConnectTicket(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
-	ticket string) *client.Client {
+// Subscribe writes every event received on the topics to out until ctx is
+// done, the router goes away or params.Count events arrived.
+func Subscribe(ctx context.Context, session *client.Client, out *Output, params SubscribeParams) error {
+	tracker := &eventTracker{target: params.Count, expect: params.Expect, done: make(chan struct{})}
+	if params.Record != nil {
+		tracker.recorder = &recorder{w: params.Record}
+	}
+
+	parent := ctx
+	if params.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
+		defer cancel()
+	}
 
-	cfg := getTicketAuthConfig(realm, serializer, authid, authrole, ticket)
+	err := subscribeAll(ctx, session, out, params, tracker)
+	if err != nil || params.Count == 0 || parent.Err() != nil {
+		return err
+	}
 
-	return connect(url, cfg)
+	received := tracker.received()
+	if received >= params.Count {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("received %d of %d expected events within %s: %w", received, params.Count,
+			params.Timeout, context.DeadlineExceeded)
+	}
+	// Neither done nor timed out, so the router went away.
+	return &ConnectionError{Err: fmt.Errorf("router gone after %d of %d expected events", received,
+		params.Count)}
 }
 
-func ConnectCRA(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
-	secret string) *client.Client {
+func subscribeAll(ctx context.Context, session *client.Client, out *Output, params SubscribeParams,
+	tracker *eventTracker) error {
 
-	cfg := getCRAAuthConfig(realm, serializer, authid, authrole, secret)
+	sessions := params.Pool.all(session)
+	if len(sessions) == 1 {
+		return subscribe(ctx, sessions[0], out, params, params.Topics, tracker)
+	}
 
-	return connect(url, cfg)
-}
+	// Every topic is subscribed on one session only, so that every event is
+	// received once.
+	assigned := make([][]Topic, len(sessions))
+	for i, topic := range params.Topics {
+		assigned[i%len(sessions)] = append(assigned[i%len(sessions)], topic)
+	}
 
-func ConnectCryptoSign(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
-	privateKey string) *client.Client {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	cfg := getCryptosignAuthConfig(realm, serializer, authid, authrole, privateKey)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i, session := range sessions {
+		if len(assigned[i]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(session *client.Client, topics []Topic) {
+			defer wg.Done()
+			if err := subscribe(ctx, session, out, params, topics, tracker); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(session, assigned[i])
+	}
+	wg.Wait()
 
-	return connect(url, cfg)
+	return firstErr
 }
 
-func Subscribe(session *client.Client, topic string, subscribeOptions map[string]string, printDetails bool) {
-	// Define function to handle events received.
-	eventHandler := func(event *wamp.Event) {
-		if printDetails {
-			argsKWArgs(event.Arguments, event.ArgumentsKw, event.Details)
-		} else {
-			argsKWArgs(event.Arguments, event.ArgumentsKw, nil)
+// subscribe subscribes session to topics, a subset of params.Topics, and
+// writes their events to out.
+func subscribe(ctx context.Context, session *client.Client, out *Output, params SubscribeParams,
+	topics []Topic, tracker *eventTracker) error {
+	options, err := dictToWampDict(params.Options, false)
+	if err != nil {
+		return fmt.Errorf("invalid option %w", err)
+	}
+
+	// Events are tagged with their topic in text mode when that is not obvious.
+	tag := len(params.Topics) > 1
+	for _, topic := range params.Topics {
+		if topicOptions(topic, options)[wamp.OptMatch] != wamp.MatchExact {
+			tag = true
 		}
 	}
 
-	// Subscribe to topic.
-	err := session.Subscribe(topic, eventHandler, dictToWampDict(subscribeOptions))
-	if err != nil {
-		logger.Fatal("subscribe error:", err)
-	} else {
-		logger.Printf("Subscribed to topic '%s'\n", topic)
+	setup := func(session *client.Client) error {
+		for _, topic := range topics {
+			topic := topic
+			eventHandler := func(event *wamp.Event) {
+				var details wamp.Dict
+				if params.PrintDetails {
+					details = event.Details
+				}
+
+				// Pattern subscriptions carry the concrete topic in the details.
+				uri, ok := wamp.AsURI(event.Details["topic"])
+				if !ok {
+					uri = wamp.URI(topic.URI)
+				}
+
+				tracker.add(string(uri), event, func() error {
+					return out.event(string(uri), tag, event.Arguments, event.ArgumentsKw, details)
+				})
+			}
+
+			if err := session.Subscribe(topic.URI, eventHandler, topicOptions(topic, options)); err != nil {
+				return fmt.Errorf("subscribing to %s: %w", topic.URI, err)
+			}
+			logger.Printf("Subscribed to topic '%s'\n", topic.URI)
+		}
+		return nil
 	}
-	// Wait for CTRL-c or client close while handling events.
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
-	select {
-	case <-sigChan:
-	case <-session.Done():
-		logger.Print("Router gone, exiting")
-		return // router gone, just exit
+
+	// Subscribe to topics.
+	if err = setup(session); err != nil {
+		return err
+	}
+
+	// Wait for cancellation, client close or enough events while handling events.
+	live, err := serve(ctx, session, params.Reconnect, tracker.done, setup)
+	if live == nil {
+		return err // router gone
+	} else if live != session {
+		defer live.Close()
 	}
 
-	// Unsubscribe from topic.
-	if err = session.Unsubscribe(topic); err != nil {
-		logger.Println("Failed to unsubscribe:", err)
+	// Unsubscribe from topics.
+	for _, topic := range topics {
+		if err = live.Unsubscribe(topic.URI); err != nil {
+			logger.Println("Failed to unsubscribe:", err)
+		}
 	}
+	return nil
 }
 
-func actualPublish(session *client.Client, topic string, args []string, kwargs map[string]string, logPublishTime bool,
-	delayPublish int, group *sync.WaitGroup, publishOptions map[string]string) {
-	if group != nil {
-		defer group.Done()
+// topicOptions returns the subscribe options for topic, its match policy
+// overrides the one in options.
+func topicOptions(topic Topic, options wamp.Dict) wamp.Dict {
+	merged := wamp.Dict{wamp.OptMatch: wamp.MatchExact}
+	for key, value := range options {
+		merged[key] = value
 	}
-	if delayPublish > 0 {
-		time.Sleep(time.Duration(delayPublish) * time.Millisecond)
+	if topic.Match != "" {
+		merged[wamp.OptMatch] = topic.Match
+	}
+	return merged
+}
+
+// PublishParams configures Publish.
+type PublishParams struct {
+	Topic   string
+	Args    []string
+	Kwargs  map[string]string
+	Options map[string]string
+	LogTime bool
+	// Repeat is the number of events to publish, at most Concurrency at a time.
+	Repeat      int
+	Concurrency int
+	Load
+	// Delay is waited before each publish.
+	Delay time.Duration
+	// RawStrings sends untyped arguments as strings instead of guessing their type.
+	RawStrings bool
+	// ExtraArgs are appended to Args and ExtraKwargs are added to Kwargs
+	// without conversion, Kwargs take precedence.
+	ExtraArgs   wamp.List
+	ExtraKwargs wamp.Dict
+	// Stats, if set, collects latencies and errors; failed publishes are then
+	// counted instead of stopping the run.
+	Stats *Stats
+	// Pool, if set, spreads the events over its sessions instead of session.
+	Pool *Pool
+	// Acknowledge waits for the router to confirm every publish, so that
+	// latency and rejected publishes can be measured.
+	Acknowledge bool
+	// Replay, if set, publishes the events of a recording made by Subscribe
+	// with their original timing instead of Repeat events. Topic, if set,
+	// replaces the recorded topics.
+	Replay io.Reader
+	// Speed divides the original timing of a replay, 2 replays twice as fast.
+	Speed float64
+	// Stream, if set, publishes one event per NDJSON line read from it instead
+	// of Repeat events. Every line is a list of arguments or an object with
+	// args and kwargs.
+	Stream io.Reader
+}
+
+func actualPublish(ctx context.Context, session *client.Client, params PublishParams, payload *payload,
+	req request) error {
+	if err := sleep(ctx, params.Delay); err != nil {
+		return err
 	}
 
 	var startTime int64
-	if logPublishTime {
+	if params.LogTime {
 		startTime = time.Now().UnixMilli()
 	}
 
 	// Publish to topic.
-	err := session.Publish(topic, dictToWampDict(publishOptions), listToWampList(args), dictToWampDict(kwargs))
+	publishStart := req.start()
+	err := publishError(params.Topic, session.Publish(params.Topic, payload.copyOptions(), payload.args,
+		payload.kwargs))
+	if !req.warmUp {
+		params.Stats.add(time.Since(publishStart), err)
+	}
 	if err != nil {
-		logger.Fatal("Publish error:", err)
-	} else {
-		logger.Printf("Published to topic '%s'\n", topic)
+		return err
 	}
+	logger.Printf("Published to topic '%s'\n", params.Topic)
 
-	if logPublishTime {
+	if params.LogTime {
 		endTime := time.Now().UnixMilli()
-		logger.Printf("call took %dms\n", endTime-startTime)
+		logger.Printf("publish took %dms\n", endTime-startTime)
+	}
+	return nil
+}
+
+// publishError turns the ERROR reply to an acknowledged publish, which nexus
+// only returns as text, into a *WampError.
+func publishError(topic string, err error) error {
+	const prefix = "waiting for published message: "
+	if err == nil || !strings.HasPrefix(err.Error(), prefix) {
+		return err
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(err.Error(), prefix), ": ", 2)
+	wampErr := &WampError{Topic: topic, URI: wamp.URI(parts[0])}
+	if len(parts) == 2 {
+		wampErr.Args = wamp.List{parts[1]}
 	}
+	return wampErr
 }
 
-func Publish(session *client.Client, topic string, args []string, kwargs map[string]string, publishOptions map[string]string,
-	logPublishTime bool, repeatPublish int, delayPublish int, concurrency int) {
+// Publish publishes params.Repeat events to the topic, events for
+// params.Duration, one event per line of params.Stream or the events of
+// params.Replay.
+func Publish(ctx context.Context, session *client.Client, params PublishParams) error {
+	if err := params.Load.validate(params.Repeat, params.Delay); err != nil {
+		return err
+	}
 
 	var startTime int64
-	if logPublishTime {
+	if params.LogTime {
 		startTime = time.Now().UnixMilli()
 	}
 
-	if concurrency > 1 {
-		concurrentGoroutines := make(chan struct{}, concurrency)
-		var wg sync.WaitGroup
-
-		for i := 0; i < repeatPublish; i++ {
-			wg.Add(1)
-			concurrentGoroutines <- struct{}{}
-			go func() {
-				actualPublish(session, topic, args, kwargs, logPublishTime, delayPublish, &wg, publishOptions)
-				<-concurrentGoroutines
-			}()
-		}
+	payload, err := newPayload(params.Args, params.Kwargs, params.Options, params.RawStrings)
+	if err != nil {
+		return err
+	}
+	payload.extend(params.ExtraArgs, params.ExtraKwargs)
+	if params.Acknowledge {
+		payload.options[wamp.OptAcknowledge] = true
+	}
 
-		wg.Wait()
+	var count int
+	if params.Replay != nil {
+		params.Stats.begin(time.Now())
+		count, err = replay(ctx, params.Replay, params.Topic, params.Speed,
+			func(ctx context.Context, topic string, args wamp.List, kwargs wamp.Dict) error {
+				eventParams := params
+				eventParams.Topic = topic
+				return params.Stats.keepGoing(ctx, actualPublish(ctx, params.Pool.pick(session), eventParams,
+					payload.with(args, kwargs), request{}))
+			})
+		params.Stats.finish()
+	} else if params.Stream != nil {
+		params.Stats.begin(time.Now())
+		err = readStream(params.Stream, func(args wamp.List, kwargs wamp.Dict) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			count++
+			return params.Stats.keepGoing(ctx, actualPublish(ctx, params.Pool.pick(session), params,
+				payload.with(args, kwargs), request{}))
+		})
+		params.Stats.finish()
 	} else {
-		for i := 0; i < repeatPublish; i++ {
-			actualPublish(session, topic, args, kwargs, logPublishTime, delayPublish, nil, publishOptions)
-		}
+		count, err = params.Load.run(ctx, params.Repeat, params.Concurrency, params.Stats,
+			func(ctx context.Context, req request) error {
+				return params.Stats.keepGoing(ctx, actualPublish(ctx, params.Pool.pick(session), params, payload, req))
+			})
+	}
+	if err != nil {
+		return err
 	}
 
-	if logPublishTime && repeatPublish > 1 {
+	if params.LogTime && count > 1 {
 		endTime := time.Now().UnixMilli()
-		logger.Printf("%d calls took %dms\n", repeatPublish, endTime-startTime)
+		logger.Printf("%d calls took %dms\n", count, endTime-startTime)
 	}
+	return params.Stats.Err()
 }
 
-func Register(session *client.Client, procedure string, command string, delay int, invokeCount int, registerOptions map[string]string) {
+// RegisterParams configures Register.
+type RegisterParams struct {
+	Procedure string
+	// Command is a shell command whose output is returned to the caller. It
+	// gets the invocation as JSON on stdin and in WICK_* environment variables.
+	Command string
+	// Template replaces placeholders such as {{args.0}} or {{kwargs.name}} in
+	// Command with the shell quoted invocation values.
+	Template bool
+	// ErrorURI is returned when Command fails, defaults to
+	// wick.error.command_failed.
+	ErrorURI string
+	// ParseJSON returns stdout that is valid JSON as a value instead of a
+	// string; an object with only args and kwargs sets both.
+	ParseJSON bool
+	// Progress sends every line of output as a progressive result to callers
+	// that asked for them, followed by an empty final result. With ParseJSON
+	// every line is parsed on its own.
+	Progress bool
+	// ExecTimeout, if positive, stops commands running longer and fails the
+	// invocation with wamp.error.timeout.
+	ExecTimeout time.Duration
+	// ReturnArgs and ReturnKwargs are returned to every caller instead of
+	// running Command. With Template placeholders in their strings are
+	// replaced with the invocation values.
+	ReturnArgs   wamp.List
+	ReturnKwargs wamp.Dict
+	// ReturnError, if set, fails every invocation with this URI and
+	// ReturnArgs and ReturnKwargs as its payload.
+	ReturnError string
+	// Latency is waited before every result to simulate a slow procedure.
+	Latency time.Duration
+	// Responses are returned in turn instead of running Command, the last one
+	// to all further invocations.
+	Responses []MockResponse
+	// MaxConcurrency, if positive, limits how many invocations are handled
+	// at once; further invocations wait for a free slot.
+	MaxConcurrency int
+	// MaxQueue, if positive, limits how many invocations wait for a slot;
+	// further invocations fail with wick.error.queue_full. It requires
+	// MaxConcurrency.
+	MaxQueue int
+	Options  map[string]string
+	// Delay is waited before registering.
+	Delay time.Duration
+	// InvokeCount, if positive, unregisters after that many invocations.
+	InvokeCount int
+	// Reconnect, if set, re-registers after the router connection is lost.
+	Reconnect *Reconnect
+}
+
+// Register serves the procedure until ctx is done, the router goes away or
+// params.InvokeCount invocations have been handled.
+func Register(ctx context.Context, session *client.Client, out *Output, params RegisterParams) error {
 
 	// If the user has called with --invoke-count
-	hasMaxInvokeCount := invokeCount > 0
+	var invokeCount int64
+	var invocations int64
+	var reachedOnce sync.Once
+	invokeCountReached := make(chan struct{})
+	limiter := newInvocationLimiter(params.MaxConcurrency, params.MaxQueue)
+
+	if params.Command != "" && params.mocked() {
+		return errors.New("a command cannot be combined with a static result or error")
+	}
+	if params.MaxQueue > 0 && params.MaxConcurrency <= 0 {
+		return errors.New("a queue limit requires a concurrency limit")
+	}
 
-	eventHandler := func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+	options, err := dictToWampDict(params.Options, false)
+	if err != nil {
+		return fmt.Errorf("invalid option %w", err)
+	}
 
-		argsKWArgs(inv.Arguments, inv.ArgumentsKw, nil)
+	eventHandler := func(session *client.Client) client.InvocationHandler {
+		return func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+			release, err := limiter.acquire(ctx)
+			if errors.Is(err, errQueueFull) {
+				return client.InvokeResult{Err: ErrQueueFull, Args: wamp.List{err.Error()}}
+			} else if err != nil {
+				return client.InvokeResult{Err: wamp.ErrCanceled}
+			}
+			defer release()
 
-		result := ""
+			if err := out.argsKWArgs(inv.Arguments, inv.ArgumentsKw, nil); err != nil {
+				logger.Errorln(err)
+			}
 
-		if command != "" {
-			err, out, _ := shellOut(command)
-			if err != nil {
-				logger.Println("error: ", err)
+			var progress func(args wamp.List, kwargs wamp.Dict) error
+			if params.Progress && inv.Details[wamp.OptReceiveProgress] == true {
+				progress = func(args wamp.List, kwargs wamp.Dict) error {
+					return session.SendProgress(ctx, args, kwargs)
+				}
 			}
-			result = out
-		}
 
-		if hasMaxInvokeCount {
-			invokeCount--
-			if invokeCount == 0 {
-				session.Unregister(procedure)
-				time.AfterFunc(1*time.Second, func() {
-					logger.Println("session closing")
-					session.Close()
-				})
+			if err := sleep(ctx, params.Latency); err != nil {
+				return client.InvokeResult{Err: wamp.ErrCanceled}
+			}
+
+			result := client.InvokeResult{Args: wamp.List{""}}
+			if params.Command != "" {
+				result = runCommand(ctx, params, inv, progress)
+			} else if params.mocked() {
+				n := atomic.AddInt64(&invocations, 1) - 1
+				result = mockResult(ctx, params, inv, int(n))
 			}
-		}
 
-		return client.InvokeResult{Args: wamp.List{result}}
+			if params.InvokeCount > 0 && atomic.AddInt64(&invokeCount, 1) >= int64(params.InvokeCount) {
+				reachedOnce.Do(func() { close(invokeCountReached) })
+			}
 
+			return result
+		}
 	}
 
-	if delay > 0 {
-		logger.Printf("procedure will be registered after %d milliseconds.\n", delay)
-		time.Sleep(time.Duration(delay) * time.Millisecond)
+	register := func(session *client.Client) error {
+		if err := session.Register(params.Procedure, eventHandler(session), options); err != nil {
+			return err
+		}
+		logger.Printf("Registered procedure '%s'\n", params.Procedure)
+		return nil
 	}
 
-	if err := session.Register(procedure, eventHandler, dictToWampDict(registerOptions)); err != nil {
-		logger.Fatal("Failed to register procedure:", err)
-	} else {
-		logger.Printf("Registered procedure '%s'\n", procedure)
+	if params.Delay > 0 {
+		logger.Printf("procedure will be registered after %s.\n", params.Delay)
+		if err := sleep(ctx, params.Delay); err != nil {
+			return nil
+		}
 	}
 
-	// Wait for CTRL-c or client close while handling remote procedure calls.
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
-	select {
-	case <-sigChan:
-	case <-session.Done():
-		logger.Print("Router gone, exiting")
-		return // router gone, just exit
+	if err = register(session); err != nil {
+		return err
 	}
 
-	if err := session.Unregister(procedure); err != nil {
-		logger.Println("Failed to unregister procedure:", err)
+	// Wait for cancellation or client close while handling remote procedure calls.
+	live, err := serve(ctx, session, params.Reconnect, invokeCountReached, register)
+	if live == nil {
+		return err // router gone
+	} else if live != session {
+		defer live.Close()
 	}
 
-	logger.Println("Registered procedure with router")
+	if err = live.Unregister(params.Procedure); err != nil {
+		logger.Println("Failed to unregister procedure:", err)
+	}
 
+	select {
+	case <-invokeCountReached:
+		// give the last result a moment to reach the router.
+		time.Sleep(1 * time.Second)
+		logger.Println("session closing")
+		live.Close()
+	default:
+		logger.Println("Registered procedure with router")
+	}
+	return nil
 }
 
-func actuallyCall(session *client.Client, procedure string, args []string, kwargs map[string]string, logCallTime bool,
-	delayCall int, group *sync.WaitGroup, callOptions map[string]string) {
-
-	if group != nil {
-		defer group.Done()
-	}
+// CallParams configures Call.
+type CallParams struct {
+	Procedure string
+	Args      []string
+	Kwargs    map[string]string
+	Options   map[string]string
+	LogTime   bool
+	// Repeat is the number of calls to make, at most Concurrency at a time.
+	Repeat      int
+	Concurrency int
+	Load
+	// Delay is waited before each call.
+	Delay time.Duration
+	// Result selects which part of the result is written, defaults to the
+	// first positional result or, without those, the keyword results.
+	Result ResultShape
+	// ResultDetails also writes the result details.
+	ResultDetails bool
+	// RawStrings sends untyped arguments as strings instead of guessing their type.
+	RawStrings bool
+	// ExtraArgs are appended to Args and ExtraKwargs are added to Kwargs
+	// without conversion, Kwargs take precedence.
+	ExtraArgs   wamp.List
+	ExtraKwargs wamp.Dict
+	// Timeout, if positive, bounds each call on the client and is sent to the
+	// router as the timeout call option.
+	Timeout time.Duration
+	// CancelMode is sent when a call is canceled: skip, kill or killnowait.
+	CancelMode string
+	// Stats, if set, collects latencies and errors; failed calls are then
+	// counted instead of stopping the run.
+	Stats *Stats
+	// Pool, if set, spreads the calls over its sessions instead of session.
+	Pool *Pool
+}
 
-	if delayCall > 0 {
-		time.Sleep(time.Duration(delayCall) * time.Millisecond)
+func actuallyCall(ctx context.Context, session *client.Client, out *Output, params CallParams,
+	payload *payload, req request) error {
+	if err := sleep(ctx, params.Delay); err != nil {
+		return err
 	}
 
 	var startTime int64
-	if logCallTime {
+	if params.LogTime {
 		startTime = time.Now().UnixMilli()
 	}
 
-	options := dictToWampDict(callOptions)
-	var result *wamp.Result
-	var err error
+	options := payload.copyOptions()
+	if params.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
+		defer cancel()
+
+		if _, ok := options[wamp.OptTimeout]; !ok {
+			options[wamp.OptTimeout] = params.Timeout.Milliseconds()
+		}
+	}
+
+	var progressHandler client.ProgressHandler
 	if options["receive_progress"] != nil && options["receive_progress"] == true {
-		result, err = session.Call(context.Background(), procedure, options, listToWampList(args), dictToWampDict(kwargs), func(progress *wamp.Result) {
-			progressArgsKWArgs(progress.Arguments, progress.ArgumentsKw)
-		})
-	} else {
-		result, err = session.Call(context.Background(), procedure, options, listToWampList(args), dictToWampDict(kwargs), nil)
+		progressHandler = func(progress *wamp.Result) {
+			if err := out.progress(progress, params.Result, params.ResultDetails); err != nil {
+				logger.Errorln(err)
+			}
+		}
 	}
+
+	callStart := req.start()
+	result, err := session.Call(ctx, params.Procedure, options, payload.args, payload.kwargs, progressHandler)
 	if err != nil {
-		logger.Fatal(err)
-	} else if result != nil && len(result.Arguments) > 0 {
-		jsonString, err := json.MarshalIndent(result.Arguments[0], "", "    ")
-		if err != nil {
-			logger.Fatal(err)
+		var rpcError client.RPCError
+		if errors.As(err, &rpcError) {
+			err = &WampError{Procedure: params.Procedure, URI: rpcError.Err.Error, Args: rpcError.Err.Arguments,
+				Kwargs: rpcError.Err.ArgumentsKw, Details: rpcError.Err.Details}
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("calling %s timed out after %s: %w", params.Procedure, params.Timeout, err)
+		} else if errors.Is(err, context.Canceled) {
+			err = fmt.Errorf("calling %s canceled: %w", params.Procedure, err)
 		}
-		fmt.Println(string(jsonString))
+	}
+	if !req.warmUp {
+		params.Stats.add(time.Since(callStart), err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = out.result(result, params.Result, params.ResultDetails); err != nil {
+		return err
 	}
 
-	if logCallTime {
+	if params.LogTime {
 		endTime := time.Now().UnixMilli()
 		logger.Printf("call took %dms\n", endTime-startTime)
 	}
+	return nil
 }
 
-func Call(session *client.Client, procedure string, args []string, kwargs map[string]string,
-	logCallTime bool, repeatCount int, delayCall int, concurrency int, callOptions map[string]string) {
+// Call calls the procedure params.Repeat times or for params.Duration and
+// writes the results to out.
+func Call(ctx context.Context, session *client.Client, out *Output, params CallParams) error {
+	if err := params.Load.validate(params.Repeat, params.Delay); err != nil {
+		return err
+	}
 
 	var startTime int64
-	if logCallTime {
+	if params.LogTime {
 		startTime = time.Now().UnixMilli()
 	}
 
-	if concurrency > 1 {
-		concurrentGoroutines := make(chan struct{}, concurrency)
-		var wg sync.WaitGroup
-
-		for i := 0; i < repeatCount; i++ {
-			wg.Add(1)
-			concurrentGoroutines <- struct{}{}
-			go func() {
-				actuallyCall(session, procedure, args, kwargs, logCallTime, delayCall, &wg, callOptions)
-				<-concurrentGoroutines
-			}()
+	if params.CancelMode != "" {
+		for _, session := range params.Pool.all(session) {
+			if err := session.SetCallCancelMode(params.CancelMode); err != nil {
+				return err
+			}
 		}
+	}
 
-		wg.Wait()
-	} else {
-		for i := 0; i < repeatCount; i++ {
-			actuallyCall(session, procedure, args, kwargs, logCallTime, delayCall, nil, callOptions)
-		}
+	payload, err := newPayload(params.Args, params.Kwargs, params.Options, params.RawStrings)
+	if err != nil {
+		return err
+	}
+	payload.extend(params.ExtraArgs, params.ExtraKwargs)
+
+	count, err := params.Load.run(ctx, params.Repeat, params.Concurrency, params.Stats,
+		func(ctx context.Context, req request) error {
+			return params.Stats.keepGoing(ctx, actuallyCall(ctx, params.Pool.pick(session), out, params, payload,
+				req))
+		})
+	if err != nil {
+		return err
 	}
 
-	if logCallTime && repeatCount > 1 {
+	if params.LogTime && count > 1 {
 		endTime := time.Now().UnixMilli()
-		logger.Printf("%d calls took %dms\n", repeatCount, endTime-startTime)
+		logger.Printf("%d calls took %dms\n", count, endTime-startTime)
+	}
+	return params.Stats.Err()
+}
+
+// sleep waits for the duration or until ctx is done.
+func sleep(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
 }
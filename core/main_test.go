@@ -0,0 +1,389 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/router"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func connectTestSession(t *testing.T, url string) *client.Client {
+	session, err := Dialer{URL: url, Realm: realm, Serializer: serializer}.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return session
+}
+
+func TestDialConnectionError(t *testing.T) {
+	_, err := Dialer{URL: "ws://127.0.0.1:1/ws", Realm: realm}.Dial(context.Background())
+
+	var connectionError *ConnectionError
+	if !errors.As(err, &connectionError) {
+		t.Errorf("expected ConnectionError, got %v", err)
+	}
+}
+
+func TestDialSchemes(t *testing.T) {
+	r, wsURL, rsURL := startTestRouter(t, RouterConfig{Anonymous: true})
+
+	socket := filepath.Join(t.TempDir(), "wick.sock")
+	closer, err := router.NewRawSocketServer(r.router).ListenAndServe("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	wsHost := strings.TrimPrefix(wsURL, "ws://")
+	rsHost := strings.TrimPrefix(rsURL, "rs://")
+	for _, url := range []string{wsURL, "http://" + wsHost, rsURL, "tcp://" + rsHost, "tcp4://" + rsHost,
+		"unix://" + socket} {
+		session, err := Dialer{URL: url, Realm: realm, Serializer: serializer}.Dial(context.Background())
+		if err != nil {
+			t.Errorf("%s: %s", url, err)
+			continue
+		}
+		session.Close()
+	}
+
+	// Nothing listens on port 1, the schemes must still be accepted.
+	for _, scheme := range []string{"wss", "https", "rss", "tcps", "tcp4s", "tcp6s", "tcp6"} {
+		_, err := Dialer{URL: scheme + "://127.0.0.1:1/ws", Realm: realm}.Dial(context.Background())
+		if err == nil || strings.Contains(err.Error(), "unsupported url scheme") {
+			t.Errorf("%s: expected a connection error, got %v", scheme, err)
+		}
+	}
+
+	_, err = Dialer{URL: "ftp://127.0.0.1:1", Realm: realm}.Dial(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unsupported url scheme") {
+		t.Errorf("unknown scheme must fail, got %v", err)
+	}
+}
+
+func TestDialAuthError(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Tickets: map[string]string{authId: secret}})
+
+	_, err := Dialer{URL: url, Realm: realm, AuthMethod: "ticket", AuthID: authId, Ticket: "wrong"}.
+		Dial(context.Background())
+
+	var authError *AuthError
+	if !errors.As(err, &authError) {
+		t.Errorf("expected AuthError, got %v", err)
+	}
+}
+
+func TestCallNoSuchProcedure(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	session := connectTestSession(t, url)
+
+	err := Call(context.Background(), session, NewOutput(&bytes.Buffer{}, FormatText), CallParams{Procedure: "foo.bar", Repeat: 1})
+
+	var wampError *WampError
+	if !errors.As(err, &wampError) {
+		t.Fatalf("expected WampError, got %v", err)
+	}
+	if wampError.URI != wamp.ErrNoSuchProcedure {
+		t.Errorf("wrong error URI %s", wampError.URI)
+	}
+}
+
+func TestCallWritesResult(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	callee := connectTestSession(t, url)
+	caller := connectTestSession(t, url)
+
+	err := callee.Register("foo.echo", func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+		return client.InvokeResult{Args: inv.Arguments}
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	err = Call(context.Background(), caller, NewOutput(&buffer, FormatText), CallParams{Procedure: "foo.echo",
+		Args: []string{"hello"}, Repeat: 3, Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(buffer.String(), `"hello"`) != 3 {
+		t.Errorf("unexpected output %q", buffer.String())
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	callee := connectTestSession(t, url)
+	caller := connectTestSession(t, url)
+
+	interrupted := make(chan struct{})
+	err := callee.Register("foo.slow", func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+		<-ctx.Done()
+		close(interrupted)
+		return client.InvokeResult{Err: wamp.ErrCanceled}
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Call(context.Background(), caller, NewOutput(&bytes.Buffer{}, FormatText), CallParams{
+		Procedure: "foo.slow", Repeat: 1, Timeout: 200 * time.Millisecond, CancelMode: wamp.CancelModeKill})
+
+	var wampError *WampError
+	if !errors.Is(err, context.DeadlineExceeded) && !(errors.As(err, &wampError) && wampError.Timeout()) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	select {
+	case <-interrupted:
+	case <-time.After(time.Second):
+		t.Error("callee was not interrupted")
+	}
+}
+
+func TestPublishInvalidRepeat(t *testing.T) {
+	if err := Publish(context.Background(), nil, PublishParams{Topic: "foo.bar"}); err == nil {
+		t.Error("zero repeat count must fail")
+	}
+}
+
+func TestPublishAcknowledge(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	publisher := connectTestSession(t, url)
+
+	err := Publish(context.Background(), publisher, PublishParams{Topic: "foo.bar", Repeat: 1, Acknowledge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Publish(context.Background(), publisher, PublishParams{Topic: "foo..bar", Repeat: 1, Acknowledge: true})
+	var wampError *WampError
+	if !errors.As(err, &wampError) {
+		t.Fatalf("expected WampError, got %v", err)
+	}
+	if wampError.URI != wamp.ErrInvalidURI || wampError.Topic != "foo..bar" {
+		t.Errorf("wrong error %+v", wampError)
+	}
+}
+
+func TestSubscribeStopsOnCancel(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	subscriber := connectTestSession(t, url)
+	publisher := connectTestSession(t, url)
+
+	var buffer bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(ctx, subscriber, NewOutput(&buffer, FormatText), SubscribeParams{
+			Topics: []Topic{{URI: "foo.bar"}}})
+	}()
+
+	// give the subscription time to be established
+	time.Sleep(100 * time.Millisecond)
+	err := Publish(context.Background(), publisher, PublishParams{Topic: "foo.bar", Args: []string{"hello"},
+		Repeat: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), `"hello"`) {
+		t.Errorf("event not written, got %q", buffer.String())
+	}
+}
+
+func TestSubscribeMultipleTopics(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	subscriber := connectTestSession(t, url)
+	publisher := connectTestSession(t, url)
+
+	var buffer bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(ctx, subscriber, NewOutput(&buffer, FormatNDJSON), SubscribeParams{
+			Topics: []Topic{{URI: "foo.bar"}, {URI: "app.", Match: wamp.MatchPrefix}}})
+	}()
+
+	// give the subscriptions time to be established
+	time.Sleep(100 * time.Millisecond)
+	for _, topic := range []string{"foo.bar", "app.users.created"} {
+		if err := Publish(context.Background(), publisher, PublishParams{Topic: topic, Repeat: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{`"topic":"foo.bar"`, `"topic":"app.users.created"`} {
+		if !strings.Contains(buffer.String(), topic) {
+			t.Errorf("missing %s in %q", topic, buffer.String())
+		}
+	}
+}
+
+func TestSubscribeCountAndTimeout(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	subscriber := connectTestSession(t, url)
+	publisher := connectTestSession(t, url)
+
+	expectation, err := ParseExpectation("args.0=ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(context.Background(), subscriber, NewOutput(&bytes.Buffer{}, FormatText), SubscribeParams{
+			Topics: []Topic{{URI: "foo.bar"}}, Count: 2, Timeout: 5 * time.Second,
+			Expect: []Expectation{expectation}})
+	}()
+
+	// give the subscription time to be established
+	time.Sleep(100 * time.Millisecond)
+	for _, arg := range []string{"ready", "starting", "ready"} {
+		err = Publish(context.Background(), publisher, PublishParams{Topic: "foo.bar", Args: []string{arg}, Repeat: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscribe did not stop after the expected events")
+	}
+
+	err = Subscribe(context.Background(), subscriber, NewOutput(&bytes.Buffer{}, FormatText), SubscribeParams{
+		Topics: []Topic{{URI: "foo.bar"}}, Count: 1, Timeout: 100 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
+
+// slowWriter delays every write so that concurrent event handlers overlap.
+type slowWriter struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(20 * time.Millisecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buffer.Write(p)
+}
+
+func (w *slowWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buffer.String()
+}
+
+func TestSubscribeCountIsExact(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	subscriber := connectTestSession(t, url)
+	publisher := connectTestSession(t, url)
+
+	var output, record slowWriter
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(context.Background(), subscriber, NewOutput(&output, FormatText), SubscribeParams{
+			Topics: []Topic{{URI: "foo.bar"}}, Count: 2, Timeout: 5 * time.Second, Record: &record})
+	}()
+
+	// give the subscription time to be established
+	time.Sleep(100 * time.Millisecond)
+	err := Publish(context.Background(), publisher, PublishParams{Topic: "foo.bar", Args: []string{"hello"},
+		Repeat: 5, Concurrency: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscribe did not stop after the expected events")
+	}
+
+	// let events published past the count reach the handlers
+	time.Sleep(200 * time.Millisecond)
+	if lines := strings.Count(record.String(), "\n"); lines != 2 {
+		t.Errorf("expected 2 recorded events, got %d: %q", lines, record.String())
+	}
+	if lines := strings.Count(output.String(), "hello"); lines != 2 {
+		t.Errorf("expected 2 printed events, got %d: %q", lines, output.String())
+	}
+}
+
+func TestSubscribeCountRouterGone(t *testing.T) {
+	r, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	subscriber := connectTestSession(t, url)
+
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(context.Background(), subscriber, NewOutput(&bytes.Buffer{}, FormatText), SubscribeParams{
+			Topics: []Topic{{URI: "foo.bar"}}, Count: 5, Timeout: 10 * time.Second})
+	}()
+
+	// give the subscription time to be established
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	select {
+	case err := <-done:
+		var connectionError *ConnectionError
+		if !errors.As(err, &connectionError) {
+			t.Errorf("expected ConnectionError, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscribe did not stop when the router went away")
+	}
+}
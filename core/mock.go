@@ -0,0 +1,328 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"gopkg.in/yaml.v3"
+)
+
+// MockDuration is a duration in a mock spec. It must be given with its unit,
+// such as "500ms" or "2s", as a bare number would be ambiguous.
+type MockDuration time.Duration
+
+// UnmarshalYAML parses a duration string and rejects bare numbers.
+func (d *MockDuration) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!str" {
+		return fmt.Errorf("line %d: duration %q needs a unit, such as 500ms or 2s", node.Line, node.Value)
+	}
+
+	duration, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = MockDuration(duration)
+	return nil
+}
+
+// MockResponse is a canned result of a mocked procedure.
+type MockResponse struct {
+	Args   wamp.List `yaml:"args"`
+	Kwargs wamp.Dict `yaml:"kwargs"`
+	// Error, if set, fails the invocation with this URI and Args and Kwargs
+	// as its payload.
+	Error string `yaml:"error"`
+	// Delay is waited before the response is returned.
+	Delay MockDuration `yaml:"delay"`
+}
+
+// MockProcedure is a procedure registered by Mock. It returns its inline
+// response, runs Command, or returns Responses in turn, repeating the last.
+type MockProcedure struct {
+	Procedure string `yaml:"procedure"`
+	// Match is exact, prefix or wildcard.
+	Match string `yaml:"match"`
+	// Command is a shell command as with RegisterParams.Command.
+	Command string `yaml:"command"`
+	// Template replaces placeholders such as {{args.0}} in the command or the
+	// responses with the invocation values.
+	Template     bool `yaml:"template"`
+	MockResponse `yaml:",inline"`
+	Responses    []MockResponse `yaml:"responses"`
+}
+
+// MockPublish is an event published in reaction to another one.
+type MockPublish struct {
+	Topic  string    `yaml:"topic"`
+	Args   wamp.List `yaml:"args"`
+	Kwargs wamp.Dict `yaml:"kwargs"`
+	// Delay is waited before publishing.
+	Delay MockDuration `yaml:"delay"`
+}
+
+// MockEvent subscribes to a topic and publishes events whenever it receives one.
+type MockEvent struct {
+	Topic string `yaml:"topic"`
+	// Match is exact, prefix or wildcard.
+	Match string `yaml:"match"`
+	// Template replaces placeholders such as {{args.0}} in the published
+	// events with the values of the received one.
+	Template bool          `yaml:"template"`
+	Publish  []MockPublish `yaml:"publish"`
+}
+
+// MockSpec describes the procedures and subscriptions of a mocked backend.
+type MockSpec struct {
+	Procedures []MockProcedure `yaml:"procedures"`
+	Events     []MockEvent     `yaml:"events"`
+}
+
+// ReadMockSpec reads a YAML or JSON mock spec from path, "-" reads stdin.
+func ReadMockSpec(path string) (MockSpec, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return MockSpec{}, err
+	}
+
+	var spec MockSpec
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+		return MockSpec{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err = spec.validate(); err != nil {
+		return MockSpec{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return spec, nil
+}
+
+func (s MockSpec) validate() error {
+	if len(s.Procedures) == 0 && len(s.Events) == 0 {
+		return errors.New("no procedures or events to mock")
+	}
+	for i, procedure := range s.Procedures {
+		if procedure.Procedure == "" {
+			return fmt.Errorf("procedure %d has no name", i+1)
+		}
+		params := procedure.params()
+		if params.Command != "" && params.mocked() {
+			return fmt.Errorf("%s: a command cannot be combined with responses", procedure.Procedure)
+		}
+	}
+	for i, event := range s.Events {
+		if event.Topic == "" {
+			return fmt.Errorf("event %d has no topic", i+1)
+		}
+		for _, publish := range event.Publish {
+			if publish.Topic == "" {
+				return fmt.Errorf("%s: publish has no topic", event.Topic)
+			}
+		}
+	}
+	return nil
+}
+
+// params returns the RegisterParams serving the procedure.
+func (p MockProcedure) params() RegisterParams {
+	params := RegisterParams{
+		Procedure:    p.Procedure,
+		Command:      p.Command,
+		Template:     p.Template,
+		ReturnArgs:   p.Args,
+		ReturnKwargs: p.Kwargs,
+		ReturnError:  p.Error,
+		Latency:      time.Duration(p.Delay),
+		Responses:    p.Responses,
+	}
+	if p.Match != "" {
+		params.Options = map[string]string{wamp.OptMatch: p.Match}
+	}
+	return params
+}
+
+// Mock registers the procedures and subscribes to the events of spec on
+// session and serves them until ctx is done or the router goes away.
+func Mock(ctx context.Context, session *client.Client, out *Output, spec MockSpec) error {
+	if err := spec.validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(spec.Procedures)+1)
+	serveAll := func(fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+
+	for _, procedure := range spec.Procedures {
+		params := procedure.params()
+		serveAll(func() error { return Register(ctx, session, out, params) })
+	}
+	if len(spec.Events) != 0 {
+		serveAll(func() error { return mockEvents(ctx, session, out, spec.Events) })
+	}
+
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// mockEvents subscribes to the topics of events and publishes their reactions
+// until ctx is done or the router goes away.
+func mockEvents(ctx context.Context, session *client.Client, out *Output, events []MockEvent) error {
+	var subscribed []string
+	defer func() {
+		for _, topic := range subscribed {
+			if err := session.Unsubscribe(topic); err != nil {
+				logger.Println("Failed to unsubscribe:", err)
+			}
+		}
+	}()
+
+	for _, event := range events {
+		event := event
+		handler := func(received *wamp.Event) {
+			// Pattern subscriptions carry the concrete topic in the details.
+			topic, ok := wamp.AsURI(received.Details["topic"])
+			if !ok {
+				topic = wamp.URI(event.Topic)
+			}
+			if err := out.event(string(topic), true, received.Arguments, received.ArgumentsKw, nil); err != nil {
+				logger.Errorln(err)
+			}
+
+			data := newInvocation(string(topic), &wamp.Invocation{Arguments: received.Arguments,
+				ArgumentsKw: received.ArgumentsKw, Details: received.Details})
+			for _, publish := range event.Publish {
+				go mockPublish(ctx, session, publish, event.Template, data)
+			}
+		}
+
+		options := wamp.Dict{}
+		if event.Match != "" {
+			options[wamp.OptMatch] = event.Match
+		}
+		if err := session.Subscribe(event.Topic, handler, options); err != nil {
+			return fmt.Errorf("subscribing to %s: %w", event.Topic, err)
+		}
+		subscribed = append(subscribed, event.Topic)
+		logger.Printf("Subscribed to topic '%s'\n", event.Topic)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-session.Done():
+		logger.Print("Router gone, exiting")
+	}
+	return nil
+}
+
+// mockPublish publishes publish after its delay, with the placeholders
+// replaced with the values of the received event if template is set.
+func mockPublish(ctx context.Context, session *client.Client, publish MockPublish, template bool,
+	data invocation) {
+	if err := sleep(ctx, time.Duration(publish.Delay)); err != nil {
+		return
+	}
+
+	args, kwargs := publish.Args, publish.Kwargs
+	if template {
+		if args != nil {
+			args = data.renderList(args)
+		}
+		if kwargs != nil {
+			kwargs = data.renderDict(kwargs)
+		}
+	}
+	if err := session.Publish(publish.Topic, nil, args, kwargs); err != nil {
+		logger.Errorln(publishError(publish.Topic, err))
+	}
+}
+
+// mocked reports whether params describe a canned result instead of a command.
+func (p RegisterParams) mocked() bool {
+	return p.ReturnArgs != nil || p.ReturnKwargs != nil || p.ReturnError != "" || len(p.Responses) != 0
+}
+
+// mockResult returns the response to the nth invocation, counting from zero:
+// params.Responses in turn, repeating the last, or else params.ReturnArgs and
+// params.ReturnKwargs, as the payload of params.ReturnError if that is set.
+// With params.Template placeholders in their strings are replaced with the
+// values of the invocation.
+func mockResult(ctx context.Context, params RegisterParams, inv *wamp.Invocation, n int) client.InvokeResult {
+	response := MockResponse{Args: params.ReturnArgs, Kwargs: params.ReturnKwargs, Error: params.ReturnError}
+	if len(params.Responses) != 0 {
+		if n >= len(params.Responses) {
+			n = len(params.Responses) - 1
+		}
+		response = params.Responses[n]
+	}
+
+	if err := sleep(ctx, time.Duration(response.Delay)); err != nil {
+		return client.InvokeResult{Err: wamp.ErrCanceled}
+	}
+
+	args, kwargs := response.Args, response.Kwargs
+	if params.Template {
+		data := newInvocation(params.Procedure, inv)
+		if args != nil {
+			args = data.renderList(args)
+		}
+		if kwargs != nil {
+			kwargs = data.renderDict(kwargs)
+		}
+	}
+
+	return client.InvokeResult{Args: args, Kwargs: kwargs, Err: wamp.URI(response.Error)}
+}
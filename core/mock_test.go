@@ -0,0 +1,192 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestRegisterMockResult(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	callee := connectTestSession(t, url)
+	caller := connectTestSession(t, url)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Register(ctx, callee, NewOutput(&bytes.Buffer{}, FormatText), RegisterParams{Procedure: "foo.user",
+		ReturnKwargs: wamp.Dict{"id": "{{args.0}}", "name": "user {{args.0}}"}, Template: true,
+		Latency: 50 * time.Millisecond})
+	go Register(ctx, callee, NewOutput(&bytes.Buffer{}, FormatText), RegisterParams{Procedure: "foo.fail",
+		ReturnError: "app.error.not_found", ReturnArgs: wamp.List{"no such user"}})
+
+	// give the registrations time to be established
+	time.Sleep(100 * time.Millisecond)
+
+	var buffer bytes.Buffer
+	err := Call(context.Background(), caller, NewOutput(&buffer, FormatNDJSON), CallParams{
+		Procedure: "foo.user", Args: []string{"7"}, Repeat: 1, Result: ResultKwargs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != `{"id":7,"name":"user 7"}`+"\n" {
+		t.Errorf("unexpected result %q", buffer.String())
+	}
+
+	err = Call(context.Background(), caller, NewOutput(&bytes.Buffer{}, FormatText), CallParams{
+		Procedure: "foo.fail", Repeat: 1})
+	var wampError *WampError
+	if !errors.As(err, &wampError) {
+		t.Fatalf("expected WampError, got %v", err)
+	}
+	if wampError.URI != "app.error.not_found" || len(wampError.Args) != 1 || wampError.Args[0] != "no such user" {
+		t.Errorf("wrong error %+v", wampError)
+	}
+}
+
+func TestRegisterMockWithCommand(t *testing.T) {
+	err := Register(context.Background(), nil, NewOutput(&bytes.Buffer{}, FormatText), RegisterParams{
+		Procedure: "foo.bar", Command: "echo", ReturnArgs: wamp.List{1}})
+	if err == nil {
+		t.Error("a command with a static result must fail")
+	}
+}
+
+const testMockSpec = `
+procedures:
+  - procedure: foo.status
+    responses:
+      - args: [pending]
+        delay: 10ms
+      - args: [done]
+  - procedure: foo.echo
+    template: true
+    kwargs: {echo: "{{args.0}}"}
+events:
+  - topic: foo.ping
+    template: true
+    publish:
+      - topic: foo.pong
+        args: ["{{args.0}}"]
+`
+
+func writeMockSpec(t *testing.T, spec string) string {
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	if err := os.WriteFile(path, []byte(spec), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadMockSpec(t *testing.T) {
+	spec, err := ReadMockSpec(writeMockSpec(t, testMockSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Procedures) != 2 || spec.Procedures[0].Responses[0].Delay != MockDuration(10*time.Millisecond) ||
+		spec.Procedures[1].Kwargs["echo"] != "{{args.0}}" || spec.Events[0].Publish[0].Topic != "foo.pong" {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+
+	_, err = ReadMockSpec(writeMockSpec(t, "procedures:\n  - procedure: foo.bar\n    arg: [1]\n"))
+	if err == nil || !strings.Contains(err.Error(), "arg") {
+		t.Errorf("unknown fields must fail, got %v", err)
+	}
+
+	for _, delay := range []string{"500", "0.5"} {
+		_, err = ReadMockSpec(writeMockSpec(t, `{"procedures": [{"procedure": "foo.bar", "delay": `+delay+`}]}`))
+		if err == nil || !strings.Contains(err.Error(), "unit") {
+			t.Errorf("numeric delay %s must fail, got %v", delay, err)
+		}
+	}
+
+	_, err = ReadMockSpec(writeMockSpec(t, "procedures:\n  - command: echo\n"))
+	if err == nil {
+		t.Error("procedures without name must fail")
+	}
+}
+
+func TestMock(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	backend := connectTestSession(t, url)
+	caller := connectTestSession(t, url)
+
+	spec, err := ReadMockSpec(writeMockSpec(t, testMockSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Mock(ctx, backend, NewOutput(&bytes.Buffer{}, FormatText), spec)
+	}()
+
+	// give the registrations and subscriptions time to be established
+	time.Sleep(100 * time.Millisecond)
+
+	var buffer bytes.Buffer
+	err = Call(context.Background(), caller, NewOutput(&buffer, FormatNDJSON), CallParams{
+		Procedure: "foo.status", Repeat: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != "\"pending\"\n\"done\"\n\"done\"\n" {
+		t.Errorf("responses not returned in turn %q", buffer.String())
+	}
+
+	var pongs bytes.Buffer
+	subscribed := make(chan error)
+	go func() {
+		subscribed <- Subscribe(context.Background(), caller, NewOutput(&pongs, FormatNDJSON), SubscribeParams{
+			Topics: []Topic{{URI: "foo.pong"}}, Count: 1, Timeout: 2 * time.Second})
+	}()
+	// give the subscription time to be established
+	time.Sleep(100 * time.Millisecond)
+	err = Publish(context.Background(), caller, PublishParams{Topic: "foo.ping", Args: []string{"s:hello"},
+		Repeat: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-subscribed; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(pongs.String(), `"args":["hello"]`) {
+		t.Errorf("reaction not published %q", pongs.String())
+	}
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
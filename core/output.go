@@ -0,0 +1,315 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"gopkg.in/yaml.v3"
+)
+
+// Format selects how Output encodes what it writes.
+type Format string
+
+const (
+	// FormatText is the human readable default.
+	FormatText Format = "text"
+	// FormatJSON writes every record as indented JSON.
+	FormatJSON Format = "json"
+	// FormatNDJSON writes every record as JSON on a single line.
+	FormatNDJSON Format = "ndjson"
+	// FormatYAML writes every record as a YAML document.
+	FormatYAML Format = "yaml"
+	// FormatRaw writes strings verbatim and everything else as compact JSON.
+	FormatRaw Format = "raw"
+)
+
+// Output is where commands write events, invocations and call results. It is
+// safe for concurrent use so that output of concurrent calls does not mix.
+type Output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+// NewOutput returns an Output writing to w in the given format.
+func NewOutput(w io.Writer, format Format) *Output {
+	return &Output{w: w, format: format}
+}
+
+func (o *Output) encode(value interface{}) ([]byte, error) {
+	var data []byte
+	var err error
+
+	switch o.format {
+	case FormatNDJSON:
+		data, err = json.Marshal(value)
+	case FormatYAML:
+		data, err = yaml.Marshal(value)
+		data = append([]byte("---\n"), data...)
+	case FormatRaw:
+		if str, ok := value.(string); ok {
+			data = []byte(str)
+		} else {
+			data, err = json.Marshal(value)
+		}
+	default:
+		data, err = json.MarshalIndent(value, "", "    ")
+	}
+	if err != nil {
+		return nil, &SerializationError{Err: err}
+	}
+
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	return data, nil
+}
+
+// write encodes one record and writes it in one piece.
+func (o *Output) write(value interface{}) error {
+	data, err := o.encode(value)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err = o.w.Write(data)
+	return err
+}
+
+// record is the structured form of an event or invocation.
+func record(args wamp.List, kwArgs wamp.Dict, details wamp.Dict) wamp.Dict {
+	if args == nil {
+		args = wamp.List{}
+	}
+	if kwArgs == nil {
+		kwArgs = wamp.Dict{}
+	}
+
+	rec := wamp.Dict{"args": args, "kwargs": kwArgs}
+	if details != nil {
+		rec["details"] = details
+	}
+	return rec
+}
+
+func (o *Output) argsKWArgs(args wamp.List, kwArgs wamp.Dict, details wamp.Dict) error {
+	if o.format != FormatText {
+		return o.write(record(args, kwArgs, details))
+	}
+
+	if details != nil {
+		logger.Println(details)
+	}
+
+	return o.writeText("", args, kwArgs)
+}
+
+// event writes an event received on topic. Structured formats always include
+// the topic, text mode only if tag is set.
+func (o *Output) event(topic string, tag bool, args wamp.List, kwArgs wamp.Dict, details wamp.Dict) error {
+	if o.format != FormatText {
+		rec := record(args, kwArgs, details)
+		rec["topic"] = topic
+		return o.write(rec)
+	}
+
+	if details != nil {
+		logger.Println(details)
+	}
+
+	var header string
+	if tag {
+		header = "topic: " + topic + "\n"
+	}
+	return o.writeText(header, args, kwArgs)
+}
+
+// writeText writes header and the legacy "args:"/"kwargs:" text in one piece.
+func (o *Output) writeText(header string, args wamp.List, kwArgs wamp.Dict) error {
+	var b bytes.Buffer
+	b.WriteString(header)
+
+	if len(args) != 0 {
+		jsonString, err := json.MarshalIndent(args, "", "    ")
+		if err != nil {
+			return &SerializationError{Err: err}
+		}
+		fmt.Fprintln(&b, "args:")
+		fmt.Fprintln(&b, string(jsonString))
+	}
+
+	if len(kwArgs) != 0 {
+		jsonString, err := json.MarshalIndent(kwArgs, "", "    ")
+		if err != nil {
+			return &SerializationError{Err: err}
+		}
+		fmt.Fprintln(&b, "kwargs:")
+		fmt.Fprintln(&b, string(jsonString))
+	}
+
+	if len(args) == 0 && len(kwArgs) == 0 {
+		fmt.Fprintln(&b, "args: []")
+		fmt.Fprintln(&b, "kwargs: {}")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err := b.WriteTo(o.w)
+	return err
+}
+
+// progress writes a progressive call result. Structured formats use the same
+// shape as the final result.
+func (o *Output) progress(result *wamp.Result, shape ResultShape, withDetails bool) error {
+	if o.format != FormatText {
+		return o.result(result, shape, withDetails)
+	}
+
+	args, kwArgs := result.Arguments, result.ArgumentsKw
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(args) != 0 {
+		fmt.Fprint(o.w, "args: ", args, "  ")
+	}
+
+	if len(kwArgs) != 0 {
+		bs, err := json.Marshal(kwArgs)
+		if err != nil {
+			return &SerializationError{Err: err}
+		}
+		fmt.Fprint(o.w, "kwargs: ")
+		fmt.Fprint(o.w, string(bs))
+	}
+
+	if len(args) == 0 && len(kwArgs) == 0 {
+		fmt.Fprint(o.w, "args: []", "kwargs: {}")
+	}
+
+	fmt.Fprintln(o.w)
+	return nil
+}
+
+// ResultShape selects which part of a call result is written.
+type ResultShape string
+
+const (
+	// ResultFirstArg writes only the first positional result, or the keyword
+	// results if there are no positional ones.
+	ResultFirstArg ResultShape = "first-arg"
+	// ResultArgs writes all positional results as a list.
+	ResultArgs ResultShape = "args"
+	// ResultKwargs writes the keyword results as an object.
+	ResultKwargs ResultShape = "kwargs"
+	// ResultEnvelope writes an object with args, kwargs and optionally details.
+	ResultEnvelope ResultShape = "envelope"
+)
+
+// resultValue extracts the value to write for the given shape. The bool is
+// false if there is nothing to write.
+func resultValue(result *wamp.Result, shape ResultShape, withDetails bool) (interface{}, bool) {
+	args := result.Arguments
+	if args == nil {
+		args = wamp.List{}
+	}
+	kwargs := result.ArgumentsKw
+	if kwargs == nil {
+		kwargs = wamp.Dict{}
+	}
+
+	switch shape {
+	case ResultArgs:
+		return args, true
+	case ResultKwargs:
+		return kwargs, true
+	case ResultEnvelope:
+		envelope := wamp.Dict{"args": args, "kwargs": kwargs}
+		if withDetails {
+			details := result.Details
+			if details == nil {
+				details = wamp.Dict{}
+			}
+			envelope["details"] = details
+		}
+		return envelope, true
+	}
+
+	if len(args) != 0 {
+		return args[0], true
+	}
+	// A result with only keyword results is written as those.
+	if len(kwargs) != 0 {
+		return kwargs, true
+	}
+	return nil, false
+}
+
+func (o *Output) result(result *wamp.Result, shape ResultShape, withDetails bool) error {
+	if result == nil {
+		return nil
+	}
+
+	if withDetails && shape != ResultEnvelope {
+		logger.Println(result.Details)
+	}
+
+	value, ok := resultValue(result, shape, withDetails)
+	if !ok {
+		return nil
+	}
+
+	return o.write(value)
+}
+
+// Error writes a WAMP error. Structured formats write the error URI along with
+// its payload, in text mode the error is logged instead.
+func (o *Output) Error(err *WampError) error {
+	if o.format == FormatText {
+		if len(err.Args) == 0 && len(err.Kwargs) == 0 {
+			logger.Errorln(err)
+		} else {
+			logger.Errorf("%s: args=%v kwargs=%v\n", err, err.Args, err.Kwargs)
+		}
+		return nil
+	}
+
+	rec := record(err.Args, err.Kwargs, nil)
+	rec["error"] = string(err.URI)
+	if err.Topic != "" {
+		rec["topic"] = err.Topic
+	} else {
+		rec["procedure"] = err.Procedure
+	}
+	return o.write(rec)
+}
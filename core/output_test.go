@@ -0,0 +1,149 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestResultValue(t *testing.T) {
+	result := &wamp.Result{
+		Arguments:   wamp.List{"first", "second"},
+		ArgumentsKw: wamp.Dict{"key": "value"},
+		Details:     wamp.Dict{"procedure": "foo.bar"},
+	}
+
+	if value, _ := resultValue(result, ResultFirstArg, false); value != "first" {
+		t.Errorf("wrong first-arg result %v", value)
+	}
+
+	if value, _ := resultValue(result, ResultArgs, false); len(value.(wamp.List)) != 2 {
+		t.Errorf("wrong args result %v", value)
+	}
+
+	if value, _ := resultValue(result, ResultKwargs, false); value.(wamp.Dict)["key"] != "value" {
+		t.Errorf("wrong kwargs result %v", value)
+	}
+
+	value, _ := resultValue(result, ResultEnvelope, true)
+	envelope := value.(wamp.Dict)
+	if envelope["args"] == nil || envelope["kwargs"] == nil || envelope["details"] == nil {
+		t.Errorf("incomplete envelope %v", envelope)
+	}
+
+	if value, _ = resultValue(result, ResultEnvelope, false); value.(wamp.Dict)["details"] != nil {
+		t.Error("details must only be included on request")
+	}
+}
+
+func TestKwargsOnlyResult(t *testing.T) {
+	result := &wamp.Result{ArgumentsKw: wamp.Dict{"key": "value"}}
+
+	for _, shape := range []ResultShape{ResultFirstArg, ResultKwargs} {
+		var buffer bytes.Buffer
+		if err := NewOutput(&buffer, FormatText).result(result, shape, false); err != nil {
+			t.Fatal(err)
+		}
+		if buffer.String() != "{\n    \"key\": \"value\"\n}\n" {
+			t.Errorf("unexpected %s output %q", shape, buffer.String())
+		}
+	}
+
+	if _, ok := resultValue(&wamp.Result{}, ResultFirstArg, false); ok {
+		t.Error("first-arg of an empty result must be empty")
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	args := wamp.List{"hello", 1}
+	kwargs := wamp.Dict{"key": "value"}
+
+	expected := map[Format]string{
+		FormatNDJSON: "{\"args\":[\"hello\",1],\"kwargs\":{\"key\":\"value\"}}\n",
+		FormatYAML:   "---\nargs:\n    - hello\n    - 1\nkwargs:\n    key: value\n",
+		FormatRaw:    "{\"args\":[\"hello\",1],\"kwargs\":{\"key\":\"value\"}}\n",
+	}
+
+	for format, output := range expected {
+		var buffer bytes.Buffer
+		if err := NewOutput(&buffer, format).argsKWArgs(args, kwargs, nil); err != nil {
+			t.Fatal(err)
+		}
+		if buffer.String() != output {
+			t.Errorf("unexpected %s output %q", format, buffer.String())
+		}
+	}
+}
+
+func TestRawStringResult(t *testing.T) {
+	var buffer bytes.Buffer
+	result := &wamp.Result{Arguments: wamp.List{"plain text"}}
+
+	if err := NewOutput(&buffer, FormatRaw).result(result, ResultFirstArg, false); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != "plain text\n" {
+		t.Errorf("unexpected raw output %q", buffer.String())
+	}
+}
+
+func TestSerializationError(t *testing.T) {
+	result := &wamp.Result{Arguments: wamp.List{make(chan int)}}
+
+	err := NewOutput(&bytes.Buffer{}, FormatNDJSON).result(result, ResultFirstArg, false)
+	if _, ok := err.(*SerializationError); !ok {
+		t.Errorf("expected SerializationError, got %v", err)
+	}
+}
+
+func TestOutputError(t *testing.T) {
+	var buf bytes.Buffer
+	out := NewOutput(&buf, FormatNDJSON)
+
+	err := &WampError{Procedure: "foo.bar", URI: "app.error.invalid", Args: wamp.List{"bad input"}}
+	if writeErr := out.Error(err); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+
+	expected := `{"args":["bad input"],"error":"app.error.invalid","kwargs":{},"procedure":"foo.bar"}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestWampErrorTimeout(t *testing.T) {
+	timedOut := &WampError{URI: wamp.ErrCanceled, Args: wamp.List{"call timeout"}}
+	if !timedOut.Timeout() {
+		t.Error("nexus call timeout not detected")
+	}
+
+	canceled := &WampError{URI: wamp.ErrCanceled}
+	if canceled.Timeout() {
+		t.Error("cancel without timeout reported as timeout")
+	}
+}
@@ -0,0 +1,245 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"gopkg.in/yaml.v3"
+)
+
+// maxLineSize bounds a single NDJSON line read from a stream.
+const maxLineSize = 16 * 1024 * 1024
+
+// decodeDocument parses a JSON or YAML document. Integral JSON numbers are
+// decoded as integers so that they keep their type with every serializer.
+func decodeDocument(data []byte) (interface{}, error) {
+	if value, err := decodeJSON(data); err == nil {
+		return value, nil
+	}
+
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("neither valid JSON nor YAML: %w", err)
+	}
+	return value, nil
+}
+
+// decodeJSON parses a single JSON value, integral numbers become int64.
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return normalizeNumbers(value), nil
+}
+
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if number, err := v.Int64(); err == nil {
+			return number
+		}
+		number, _ := v.Float64()
+		return number
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = normalizeNumbers(v[key])
+		}
+	}
+	return value
+}
+
+// payloadFromDocument accepts a list of arguments or an object with "args"
+// and/or "kwargs".
+func payloadFromDocument(value interface{}) (wamp.List, wamp.Dict, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, nil, nil
+	case map[string]interface{}:
+		var args wamp.List
+		var kwargs wamp.Dict
+		for key, item := range v {
+			switch key {
+			case "args":
+				list, ok := item.([]interface{})
+				if !ok && item != nil {
+					return nil, nil, errors.New("args must be a list")
+				}
+				args = list
+			case "kwargs":
+				dict, ok := item.(map[string]interface{})
+				if !ok && item != nil {
+					return nil, nil, errors.New("kwargs must be an object")
+				}
+				kwargs = dict
+			default:
+				return nil, nil, fmt.Errorf("unexpected key %q, expected args or kwargs", key)
+			}
+		}
+		return args, kwargs, nil
+	}
+	return nil, nil, errors.New("expected a list of arguments or an object with args and kwargs")
+}
+
+func readDocument(path string) (interface{}, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := decodeDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return value, nil
+}
+
+// ReadArgs reads a JSON or YAML list of arguments from path, "-" reads stdin.
+func ReadArgs(path string) (wamp.List, error) {
+	value, err := readDocument(path)
+	if err != nil {
+		return nil, err
+	}
+
+	args, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a list of arguments", path)
+	}
+	return args, nil
+}
+
+// ReadKwargs reads a JSON or YAML object of keyword arguments from path, "-"
+// reads stdin.
+func ReadKwargs(path string) (wamp.Dict, error) {
+	value, err := readDocument(path)
+	if err != nil {
+		return nil, err
+	}
+
+	kwargs, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected an object of keyword arguments", path)
+	}
+	return kwargs, nil
+}
+
+// ReadPayload reads a JSON or YAML document with "args" and "kwargs" from
+// path, "-" reads stdin.
+func ReadPayload(path string) (wamp.List, wamp.Dict, error) {
+	value, err := readDocument(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args, kwargs, err := payloadFromDocument(value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return args, kwargs, nil
+}
+
+// ParseArgs parses a JSON or YAML list of arguments given on the command line.
+func ParseArgs(text string) (wamp.List, error) {
+	value, err := decodeDocument([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+
+	args, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("expected a list of arguments")
+	}
+	return args, nil
+}
+
+// ParseKwargs parses a JSON or YAML object of keyword arguments given on the
+// command line.
+func ParseKwargs(text string) (wamp.Dict, error) {
+	value, err := decodeDocument([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+
+	kwargs, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("expected an object of keyword arguments")
+	}
+	return kwargs, nil
+}
+
+// readStream calls fn with the payload of every non-empty NDJSON line of r.
+func readStream(r io.Reader, fn func(args wamp.List, kwargs wamp.Dict) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		args, kwargs, err := payloadFromDocument(normalizeNumbers(value))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		if err = fn(args, kwargs); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
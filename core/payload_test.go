@@ -0,0 +1,118 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestDecodeDocumentJSON(t *testing.T) {
+	value, err := decodeDocument([]byte(`{"args": [1, 2.5, "x"], "kwargs": {"n": {"m": 3}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, kwargs, err := payloadFromDocument(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if args[0] != int64(1) || args[1] != 2.5 || args[2] != "x" {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	nested := kwargs["n"].(map[string]interface{})
+	if nested["m"] != int64(3) {
+		t.Errorf("unexpected kwargs %v", kwargs)
+	}
+}
+
+func TestDecodeDocumentYAML(t *testing.T) {
+	value, err := decodeDocument([]byte("args:\n  - 1\n  - hello\nkwargs:\n  enabled: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, kwargs, err := payloadFromDocument(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(args) != 2 || args[0] != 1 || args[1] != "hello" {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	if kwargs["enabled"] != true {
+		t.Errorf("unexpected kwargs %v", kwargs)
+	}
+}
+
+func TestPayloadFromDocumentInvalid(t *testing.T) {
+	for _, document := range []string{`"text"`, `{"args": 1}`, `{"kwargs": []}`, `{"other": 1}`} {
+		value, err := decodeDocument([]byte(document))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err = payloadFromDocument(value); err == nil {
+			t.Errorf("expected error for %s", document)
+		}
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	input := "[1, \"a\"]\n\n{\"kwargs\": {\"k\": 2}}\n"
+
+	var payloads []wamp.List
+	var kwargs []wamp.Dict
+	err := readStream(strings.NewReader(input), func(a wamp.List, kw wamp.Dict) error {
+		payloads = append(payloads, a)
+		kwargs = append(kwargs, kw)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(payloads) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(payloads))
+	}
+
+	if payloads[0][0] != int64(1) || payloads[0][1] != "a" || kwargs[1]["k"] != int64(2) {
+		t.Errorf("unexpected events %v %v", payloads, kwargs)
+	}
+}
+
+func TestReadStreamInvalidLine(t *testing.T) {
+	err := readStream(strings.NewReader("[1]\nnot json\n"), func(wamp.List, wamp.Dict) error {
+		return nil
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+}
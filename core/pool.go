@@ -0,0 +1,132 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+)
+
+// poolDialConcurrency bounds how many sessions of a pool join at once.
+const poolDialConcurrency = 64
+
+// Pool is a set of independently joined sessions over which calls, publishes
+// and subscriptions are spread.
+type Pool struct {
+	sessions []*client.Client
+	joins    *Stats
+	next     uint64
+}
+
+// DialPool joins size sessions with dialer. If any session fails to join, the
+// others are closed and the error is returned.
+func DialPool(ctx context.Context, dialer Dialer, size int) (*Pool, error) {
+	if size < 1 {
+		return nil, errors.New("pool size must be greater than zero")
+	}
+
+	pool := &Pool{sessions: make([]*client.Client, size), joins: NewStats()}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	concurrentDials := make(chan struct{}, poolDialConcurrency)
+
+	pool.joins.begin(time.Now())
+	for i := range pool.sessions {
+		concurrentDials <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-concurrentDials }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			start := time.Now()
+			session, err := dialer.Dial(ctx)
+			pool.joins.add(time.Since(start), err)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			pool.sessions[i] = session
+		}(i)
+	}
+	wg.Wait()
+	pool.joins.finish()
+
+	if firstErr != nil {
+		pool.Close()
+		return nil, firstErr
+	}
+	return pool, nil
+}
+
+// Sessions returns the sessions of the pool.
+func (p *Pool) Sessions() []*client.Client {
+	return p.sessions
+}
+
+// JoinReport summarizes how long the sessions took to join.
+func (p *Pool) JoinReport() Report {
+	return p.joins.Report()
+}
+
+// Close closes all sessions of the pool.
+func (p *Pool) Close() {
+	for _, session := range p.sessions {
+		if session != nil {
+			session.Close()
+		}
+	}
+}
+
+// pick returns the next session round robin, or fallback if p is nil.
+func (p *Pool) pick(fallback *client.Client) *client.Client {
+	if p == nil {
+		return fallback
+	}
+	return p.sessions[(atomic.AddUint64(&p.next, 1)-1)%uint64(len(p.sessions))]
+}
+
+// all returns the sessions of p, or just fallback if p is nil.
+func (p *Pool) all(fallback *client.Client) []*client.Client {
+	if p == nil {
+		return []*client.Client{fallback}
+	}
+	return p.sessions
+}
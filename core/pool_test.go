@@ -0,0 +1,105 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDialPool(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+
+	pool, err := DialPool(context.Background(), Dialer{URL: url, Realm: realm, Serializer: serializer}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if report := pool.JoinReport(); report.Succeeded != 3 {
+		t.Errorf("expected 3 joins, got %+v", report)
+	}
+
+	seen := map[interface{}]int{}
+	for i := 0; i < 6; i++ {
+		seen[pool.pick(nil).ID()]++
+	}
+	if len(seen) != 3 {
+		t.Errorf("requests not spread over all sessions: %v", seen)
+	}
+}
+
+func TestDialPoolConnectionError(t *testing.T) {
+	_, err := DialPool(context.Background(), Dialer{URL: "ws://127.0.0.1:1/ws", Realm: realm}, 2)
+
+	var connectionError *ConnectionError
+	if !errors.As(err, &connectionError) {
+		t.Errorf("expected ConnectionError, got %v", err)
+	}
+}
+
+func TestSubscribePool(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	publisher := connectTestSession(t, url)
+
+	pool, err := DialPool(context.Background(), Dialer{URL: url, Realm: realm, Serializer: serializer}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var buffer bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(ctx, nil, NewOutput(&buffer, FormatNDJSON), SubscribeParams{
+			Topics: []Topic{{URI: "foo.bar"}, {URI: "foo.baz"}}, Pool: pool})
+	}()
+
+	// give the subscriptions time to be established
+	time.Sleep(100 * time.Millisecond)
+	for _, topic := range []string{"foo.bar", "foo.baz"} {
+		err = Publish(context.Background(), publisher, PublishParams{Topic: topic, Args: []string{"hello"},
+			Repeat: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{`"topic":"foo.bar"`, `"topic":"foo.baz"`} {
+		if count := strings.Count(buffer.String(), topic); count != 1 {
+			t.Errorf("expected the event once, got %d of %s in %q", count, topic, buffer.String())
+		}
+	}
+}
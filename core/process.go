@@ -0,0 +1,78 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// killGracePeriod is how long a command may take to exit after it was asked
+// to terminate before it is killed.
+const killGracePeriod = 5 * time.Second
+
+// shellCommand returns a bash command running in its own process group, so
+// that everything it starts can be stopped with it.
+func shellCommand(command string) *exec.Cmd {
+	cmd := exec.Command("bash", "-c", command)
+	setProcessGroup(cmd)
+	return cmd
+}
+
+// watchProcess stops the started cmd and its process group when ctx is done:
+// it is asked to terminate first and killed after killGracePeriod. The
+// returned function must be called once cmd has exited.
+func watchProcess(ctx context.Context, cmd *exec.Cmd) func() {
+	exited := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		}
+
+		if err := terminateProcessGroup(cmd); err != nil {
+			logger.Debugln("terminating command failed:", err)
+		}
+		timer := time.NewTimer(killGracePeriod)
+		defer timer.Stop()
+		select {
+		case <-exited:
+		case <-timer.C:
+			if err := killProcessGroup(cmd); err != nil {
+				logger.Debugln("killing command failed:", err)
+			}
+		}
+	}()
+
+	return func() {
+		close(exited)
+		<-stopped
+	}
+}
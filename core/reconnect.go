@@ -0,0 +1,137 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+)
+
+// Backoff configures the delay between reconnect attempts. The delay starts at
+// InitialDelay and is multiplied by Multiplier after every failed attempt, up
+// to MaxDelay. Jitter randomizes each delay by up to the given fraction.
+type Backoff struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Jitter       float64
+	// MaxAttempts is the number of attempts per outage, zero means retry forever.
+	MaxAttempts int
+}
+
+// Delay returns how long to wait before the given attempt, starting from 1.
+func (b Backoff) Delay(attempt int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(b.InitialDelay) * math.Pow(multiplier, float64(attempt-1))
+	if b.MaxDelay > 0 && delay > float64(b.MaxDelay) {
+		delay = float64(b.MaxDelay)
+	}
+
+	if b.Jitter > 0 {
+		delay += (rand.Float64()*2 - 1) * b.Jitter * delay
+	}
+
+	return time.Duration(delay)
+}
+
+// Reconnect enables automatic reconnection for long-running commands.
+type Reconnect struct {
+	Dialer  Dialer
+	Backoff Backoff
+}
+
+// rejoin dials until a new session is established and setup succeeds on it.
+// It returns a nil session when ctx is done and a *ConnectionError when all
+// attempts are exhausted.
+func (r *Reconnect) rejoin(ctx context.Context, setup func(*client.Client) error) (*client.Client, error) {
+	outageStart := time.Now()
+
+	var lastErr error
+	for attempt := 1; r.Backoff.MaxAttempts == 0 || attempt <= r.Backoff.MaxAttempts; attempt++ {
+		delay := r.Backoff.Delay(attempt)
+		logger.Printf("Reconnect attempt %d in %s\n", attempt, delay.Round(time.Millisecond))
+
+		if err := sleep(ctx, delay); err != nil {
+			return nil, nil
+		}
+
+		session, err := r.Dialer.Dial(ctx)
+		if err == nil {
+			if err = setup(session); err != nil {
+				session.Close()
+			}
+		}
+		if err != nil {
+			logger.Warnf("Reconnect attempt %d failed: %s\n", attempt, err)
+			lastErr = err
+			continue
+		}
+
+		logger.Printf("Reconnected after %d attempt(s), outage lasted %s\n", attempt,
+			time.Since(outageStart).Round(time.Millisecond))
+		return session, nil
+	}
+
+	logger.Errorf("Giving up after %d reconnect attempts, outage lasted %s\n", r.Backoff.MaxAttempts,
+		time.Since(outageStart).Round(time.Millisecond))
+	return nil, &ConnectionError{URL: r.Dialer.URL, Err: lastErr}
+}
+
+// serve blocks until ctx is done, until stop is closed or until the router goes
+// away. If reconnect is set, a lost session is replaced by a new one on which
+// setup is run again. It returns the session that was live when serving
+// stopped or nil if the router is gone.
+func serve(ctx context.Context, session *client.Client, reconnect *Reconnect, stop <-chan struct{},
+	setup func(*client.Client) error) (*client.Client, error) {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return session, nil
+		case <-stop:
+			return session, nil
+		case <-session.Done():
+		}
+
+		if reconnect == nil {
+			logger.Print("Router gone, exiting")
+			return nil, nil
+		}
+
+		logger.Warn("Router connection lost")
+		var err error
+		if session, err = reconnect.rejoin(ctx, setup); session == nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if got := backoff.Delay(i + 1); got != delay {
+			t.Errorf("wrong delay for attempt %d, expected=%s, got=%s", i+1, delay, got)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	backoff := Backoff{InitialDelay: time.Second, Multiplier: 2, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		delay := backoff.Delay(2)
+		if delay < time.Second || delay > 3*time.Second {
+			t.Fatalf("delay %s out of jitter range", delay)
+		}
+	}
+}
+
+func TestDialerConfig(t *testing.T) {
+	dialer := Dialer{Realm: realm, Serializer: serializer, AuthMethod: "wampcra", AuthID: authId,
+		AuthRole: authRole, Secret: secret}
+
+	cfg, err := dialer.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBaseConfig(cfg, t)
+	if cfg.AuthHandlers["wampcra"] == nil {
+		t.Error("wampcra auth handler missing")
+	}
+}
+
+// startRouterAt starts a router listening for WebSocket connections on
+// address, so that it can be restarted on the same address.
+func startRouterAt(t *testing.T, address string) *LocalRouter {
+	r, err := StartRouter(RouterConfig{WebSocketAddress: address, Realms: []string{realm}, Anonymous: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestRegisterReconnects(t *testing.T) {
+	address := freeAddress(t)
+	url := "ws://" + address + "/ws"
+	r := startRouterAt(t, address)
+	callee := connectTestSession(t, url)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Register(ctx, callee, NewOutput(&bytes.Buffer{}, FormatText), RegisterParams{
+			Procedure: "foo.ping", ReturnArgs: wamp.List{"pong"},
+			Reconnect: &Reconnect{
+				Dialer:  Dialer{URL: url, Realm: realm, Serializer: serializer},
+				Backoff: Backoff{InitialDelay: 50 * time.Millisecond, MaxAttempts: 100},
+			}})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// give the registration time to be established
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+	startRouterAt(t, address)
+
+	// the callee registers again once it reconnected
+	caller := connectTestSession(t, url)
+	var buffer bytes.Buffer
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		err = Call(context.Background(), caller, NewOutput(&buffer, FormatText), CallParams{
+			Procedure: "foo.ping", Repeat: 1})
+		if err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("procedure not registered again: %s", err)
+	}
+	if !strings.Contains(buffer.String(), "pong") {
+		t.Errorf("unexpected result %q", buffer.String())
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	address := freeAddress(t)
+	url := "ws://" + address + "/ws"
+	r := startRouterAt(t, address)
+	subscriber := connectTestSession(t, url)
+
+	done := make(chan error)
+	go func() {
+		done <- Subscribe(context.Background(), subscriber, NewOutput(&bytes.Buffer{}, FormatText), SubscribeParams{
+			Topics: []Topic{{URI: "foo.bar"}},
+			Reconnect: &Reconnect{
+				Dialer:  Dialer{URL: url, Realm: realm, Serializer: serializer},
+				Backoff: Backoff{InitialDelay: 10 * time.Millisecond, MaxAttempts: 3},
+			}})
+	}()
+
+	// give the subscription time to be established
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	select {
+	case err := <-done:
+		var connectionError *ConnectionError
+		if !errors.As(err, &connectionError) {
+			t.Errorf("expected ConnectionError, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscribe did not give up reconnecting")
+	}
+}
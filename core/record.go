@@ -0,0 +1,142 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// recordedEvent is one line of a recording made by Subscribe.
+type recordedEvent struct {
+	Time    time.Time `json:"time"`
+	Topic   string    `json:"topic"`
+	Args    wamp.List `json:"args"`
+	Kwargs  wamp.Dict `json:"kwargs"`
+	Details wamp.Dict `json:"details,omitempty"`
+}
+
+// recorder writes received events as NDJSON.
+type recorder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *recorder) record(topic string, event *wamp.Event) error {
+	if r == nil {
+		return nil
+	}
+
+	args, kwargs := event.Arguments, event.ArgumentsKw
+	if args == nil {
+		args = wamp.List{}
+	}
+	if kwargs == nil {
+		kwargs = wamp.Dict{}
+	}
+
+	data, err := json.Marshal(recordedEvent{Time: time.Now(), Topic: topic, Args: args, Kwargs: kwargs,
+		Details: event.Details})
+	if err != nil {
+		return &SerializationError{Err: err}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.w.Write(append(data, '\n'))
+	return err
+}
+
+// readRecording calls fn with every event of a recording, in order.
+func readRecording(r io.Reader, fn func(event recordedEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var event recordedEvent
+		if err := decoder.Decode(&event); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if event.Topic == "" {
+			return fmt.Errorf("line %d: missing topic", lineNumber)
+		}
+
+		event.Args, _ = normalizeNumbers([]interface{}(event.Args)).([]interface{})
+		event.Kwargs, _ = normalizeNumbers(map[string]interface{}(event.Kwargs)).(map[string]interface{})
+
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// replay publishes the events of a recording with their original relative
+// timing divided by speed. The topic, if set, replaces the recorded ones.
+func replay(ctx context.Context, r io.Reader, topic string, speed float64,
+	publish func(ctx context.Context, topic string, args wamp.List, kwargs wamp.Dict) error) (int, error) {
+
+	if speed <= 0 {
+		speed = 1
+	}
+
+	var first time.Time
+	start := time.Now()
+	count := 0
+	err := readRecording(r, func(event recordedEvent) error {
+		if first.IsZero() {
+			first = event.Time
+		}
+
+		due := start.Add(time.Duration(float64(event.Time.Sub(first)) / speed))
+		if err := sleep(ctx, time.Until(due)); err != nil {
+			return err
+		}
+
+		eventTopic := event.Topic
+		if topic != "" {
+			eventTopic = topic
+		}
+		count++
+		return publish(ctx, eventTopic, event.Args, event.Kwargs)
+	})
+	return count, err
+}
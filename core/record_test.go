@@ -0,0 +1,90 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var recording bytes.Buffer
+	rec := &recorder{w: &recording}
+
+	events := []*wamp.Event{
+		{Arguments: wamp.List{1}, Details: wamp.Dict{"topic": "app.first"}},
+		{ArgumentsKw: wamp.Dict{"value": 2.5}},
+	}
+	topics := []string{"app.first", "app.second"}
+	for i, event := range events {
+		if err := rec.record(topics[i], event); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if !strings.Contains(recording.String(), `"topic":"app.first"`) {
+		t.Errorf("topic not recorded in %q", recording.String())
+	}
+
+	var replayed []string
+	start := time.Now()
+	count, err := replay(context.Background(), &recording, "", 2,
+		func(ctx context.Context, topic string, args wamp.List, kwargs wamp.Dict) error {
+			replayed = append(replayed, topic)
+			if topic == "app.first" && args[0] != int64(1) {
+				t.Errorf("wrong args %v", args)
+			}
+			if topic == "app.second" && kwargs["value"] != 2.5 {
+				t.Errorf("wrong kwargs %v", kwargs)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 2 || strings.Join(replayed, ",") != "app.first,app.second" {
+		t.Errorf("unexpected replay %v", replayed)
+	}
+
+	// 100ms apart when recorded, 50ms at double speed
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > 90*time.Millisecond {
+		t.Errorf("replay took %s, expected about 50ms", elapsed)
+	}
+}
+
+func TestReplayInvalidRecording(t *testing.T) {
+	_, err := replay(context.Background(), strings.NewReader(`{"args":[]}`), "", 1,
+		func(context.Context, string, wamp.List, wamp.Dict) error { return nil })
+	if err == nil || !strings.HasPrefix(err.Error(), "line 1:") {
+		t.Errorf("expected missing topic error, got %v", err)
+	}
+}
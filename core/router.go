@@ -0,0 +1,229 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/router"
+	"github.com/gammazero/nexus/v3/router/auth"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const (
+	defaultAuthRole = "user"
+	authTimeout     = 10 * time.Second
+)
+
+// RouterConfig configures the embedded WAMP router.
+type RouterConfig struct {
+	// WebSocketAddress and RawSocketAddress are host:port pairs to listen on,
+	// empty disables the transport.
+	WebSocketAddress string
+	RawSocketAddress string
+	Realms           []string
+	Anonymous        bool
+	// Tickets, Secrets and PublicKeys map an authid to its ticket, wampcra
+	// secret or cryptosign public key hex respectively.
+	Tickets    map[string]string
+	Secrets    map[string]string
+	PublicKeys map[string]string
+	// Roles maps an authid to its authrole, unlisted users get "user".
+	Roles map[string]string
+}
+
+// keyStore serves the credentials from RouterConfig to the nexus authenticators.
+type keyStore struct {
+	keys  map[string]map[string][]byte
+	roles map[string]string
+}
+
+func (k *keyStore) AuthKey(authid, authmethod string) ([]byte, error) {
+	key, ok := k.keys[authmethod][authid]
+	if !ok {
+		return nil, fmt.Errorf("no %s key for authid %s", authmethod, authid)
+	}
+	return key, nil
+}
+
+func (k *keyStore) PasswordInfo(string) (string, int, int) {
+	return "", 0, 0
+}
+
+func (k *keyStore) AuthRole(authid string) (string, error) {
+	if role, ok := k.roles[authid]; ok {
+		return role, nil
+	}
+	return defaultAuthRole, nil
+}
+
+func (k *keyStore) Provider() string {
+	return "wick"
+}
+
+func newKeyStore(config RouterConfig) (*keyStore, error) {
+	store := &keyStore{
+		keys: map[string]map[string][]byte{
+			"ticket":     {},
+			"wampcra":    {},
+			"cryptosign": {},
+		},
+		roles: config.Roles,
+	}
+
+	for authid, ticket := range config.Tickets {
+		store.keys["ticket"][authid] = []byte(ticket)
+	}
+
+	for authid, secret := range config.Secrets {
+		store.keys["wampcra"][authid] = []byte(secret)
+	}
+
+	for authid, publicKeyHex := range config.PublicKeys {
+		publicKey, err := hex.DecodeString(publicKeyHex)
+		if err != nil || len(publicKey) != 32 {
+			return nil, fmt.Errorf("invalid cryptosign public key for authid %s", authid)
+		}
+		store.keys["cryptosign"][authid] = publicKey
+	}
+
+	return store, nil
+}
+
+func getAuthenticators(config RouterConfig) ([]auth.Authenticator, error) {
+	store, err := newKeyStore(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var authenticators []auth.Authenticator
+	if len(config.Tickets) > 0 {
+		authenticators = append(authenticators, auth.NewTicketAuthenticator(store, authTimeout))
+	}
+	if len(config.Secrets) > 0 {
+		authenticators = append(authenticators, auth.NewCRAuthenticator(store, authTimeout))
+	}
+	if len(config.PublicKeys) > 0 {
+		authenticators = append(authenticators, auth.NewCryptoSignAuthenticator(store, authTimeout))
+	}
+
+	return authenticators, nil
+}
+
+// LocalRouter is an in-process WAMP router serving WebSocket and/or RawSocket.
+type LocalRouter struct {
+	router    router.Router
+	listeners []io.Closer
+	closeOnce sync.Once
+}
+
+// StartRouter starts a router with the given configuration.
+func StartRouter(config RouterConfig) (*LocalRouter, error) {
+	if config.WebSocketAddress == "" && config.RawSocketAddress == "" {
+		return nil, errors.New("at least one of WebSocket or RawSocket address is required")
+	}
+
+	if len(config.Realms) == 0 {
+		return nil, errors.New("at least one realm is required")
+	}
+
+	authenticators, err := getAuthenticators(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if !config.Anonymous && len(authenticators) == 0 {
+		return nil, errors.New("anonymous authentication disabled and no users configured")
+	}
+
+	routerConfig := &router.Config{}
+	for _, realm := range config.Realms {
+		routerConfig.RealmConfigs = append(routerConfig.RealmConfigs, &router.RealmConfig{
+			URI:            wamp.URI(realm),
+			AnonymousAuth:  config.Anonymous,
+			AllowDisclose:  true,
+			Authenticators: authenticators,
+		})
+	}
+
+	nxr, err := router.NewRouter(routerConfig, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &LocalRouter{router: nxr}
+
+	if config.WebSocketAddress != "" {
+		closer, err := router.NewWebsocketServer(nxr).ListenAndServe(config.WebSocketAddress)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.listeners = append(r.listeners, closer)
+		logger.Printf("WebSocket transport listening on ws://%s/ws\n", config.WebSocketAddress)
+	}
+
+	if config.RawSocketAddress != "" {
+		closer, err := router.NewRawSocketServer(nxr).ListenAndServe("tcp", config.RawSocketAddress)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.listeners = append(r.listeners, closer)
+		logger.Printf("RawSocket transport listening on rs://%s\n", config.RawSocketAddress)
+	}
+
+	return r, nil
+}
+
+// Close stops listening and shuts the router down.
+func (r *LocalRouter) Close() {
+	r.closeOnce.Do(func() {
+		for _, listener := range r.listeners {
+			listener.Close()
+		}
+		r.router.Close()
+	})
+}
+
+// ServeRouter runs a router until ctx is done.
+func ServeRouter(ctx context.Context, config RouterConfig) error {
+	r, err := StartRouter(config)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	logger.Printf("Serving realms %v\n", config.Realms)
+
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+const publicKeyHex = "22509ce613c8d604305a8134819f8ffb7ed601b3d0d94253f66fc5d81c94e714"
+
+func startTestRouter(t *testing.T, config RouterConfig) (*LocalRouter, string, string) {
+	config.WebSocketAddress = "127.0.0.1:0"
+	config.RawSocketAddress = "127.0.0.1:0"
+	config.Realms = []string{realm}
+
+	r, err := StartRouter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+
+	wsAddress := r.listeners[0].(net.Listener).Addr().String()
+	rsAddress := r.listeners[1].(net.Listener).Addr().String()
+	return r, "ws://" + wsAddress + "/ws", "rs://" + rsAddress
+}
+
+func TestRouterAnonymous(t *testing.T) {
+	_, wsURL, rsURL := startTestRouter(t, RouterConfig{Anonymous: true})
+
+	for _, url := range []string{wsURL, rsURL} {
+		session, err := Dialer{URL: url, Realm: realm, Serializer: serializer}.Dial(context.Background())
+		if err != nil {
+			t.Fatalf("failed to join over %s: %s", url, err)
+		}
+		session.Close()
+	}
+}
+
+func TestRouterAuthentication(t *testing.T) {
+	_, wsURL, _ := startTestRouter(t, RouterConfig{
+		Tickets:    map[string]string{authId: secret},
+		Secrets:    map[string]string{authId: secret},
+		PublicKeys: map[string]string{authId: publicKeyHex},
+		Roles:      map[string]string{authId: authRole},
+	})
+
+	dialers := []Dialer{
+		{AuthMethod: "ticket", Ticket: secret},
+		{AuthMethod: "wampcra", Secret: secret},
+		{AuthMethod: "cryptosign", PrivateKey: privateKeyHex},
+	}
+
+	for _, dialer := range dialers {
+		dialer.URL = wsURL
+		dialer.Realm = realm
+		dialer.Serializer = serializer
+		dialer.AuthID = authId
+
+		session, err := dialer.Dial(context.Background())
+		if err != nil {
+			t.Fatalf("%s authentication failed: %s", dialer.AuthMethod, err)
+		}
+		if session.RealmDetails()["authrole"] != authRole {
+			t.Errorf("wrong authrole for %s", dialer.AuthMethod)
+		}
+		session.Close()
+	}
+}
+
+func TestRouterRejectsAnonymous(t *testing.T) {
+	_, wsURL, _ := startTestRouter(t, RouterConfig{Tickets: map[string]string{authId: secret}})
+
+	if session, err := (Dialer{URL: wsURL, Realm: realm, Serializer: serializer}).Dial(context.Background()); err == nil {
+		session.Close()
+		t.Error("anonymous session must be rejected")
+	}
+}
+
+func TestStartRouterWithoutTransport(t *testing.T) {
+	if _, err := StartRouter(RouterConfig{Realms: []string{realm}, Anonymous: true}); err == nil {
+		t.Error("router without transport must fail")
+	}
+}
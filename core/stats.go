@@ -0,0 +1,347 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats collects the latency and outcome of every call or publish of a load
+// test run. A nil *Stats collects nothing.
+type Stats struct {
+	mu        sync.Mutex
+	start     time.Time
+	end       time.Time
+	latencies []time.Duration
+	errors    map[string]int
+	firstErr  error
+}
+
+// NewStats returns an empty Stats.
+func NewStats() *Stats {
+	return &Stats{errors: map[string]int{}}
+}
+
+// begin sets when the measured part of the run starts.
+func (s *Stats) begin(at time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.start.IsZero() {
+		s.start = at
+	}
+}
+
+func (s *Stats) finish() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.end = time.Now()
+}
+
+// extend moves the end of the measured period to at if that is later, for
+// results that arrive after the last request was finished.
+func (s *Stats) extend(at time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if at.After(s.end) {
+		s.end = at
+	}
+}
+
+// add records one request. Latencies are only kept for successful requests,
+// requests canceled by the user are not counted.
+func (s *Stats) add(latency time.Duration, err error) {
+	if s == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.errors[errorKey(err)]++
+		return
+	}
+	s.latencies = append(s.latencies, latency)
+}
+
+// keepGoing lets a run continue past failed requests while stats are
+// collected, the first error is kept and returned by Err. Errors caused by
+// ctx being done still stop the run.
+func (s *Stats) keepGoing(ctx context.Context, err error) error {
+	if s == nil || err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.firstErr == nil {
+		s.firstErr = err
+	}
+	return nil
+}
+
+// Err returns the first error of the run, if any.
+func (s *Stats) Err() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.firstErr
+}
+
+// errorKey groups errors by WAMP error URI, other errors by their message.
+func errorKey(err error) string {
+	var wampErr *WampError
+	if errors.As(err, &wampErr) {
+		return string(wampErr.URI)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return err.Error()
+}
+
+// HistogramBucket counts the requests that took at most UpperMs milliseconds
+// and longer than the previous bucket.
+type HistogramBucket struct {
+	UpperMs float64 `json:"le_ms"`
+	Count   int     `json:"count"`
+}
+
+// Report is the summary of a load test run. Latencies are in milliseconds.
+type Report struct {
+	Requests   int               `json:"requests"`
+	Succeeded  int               `json:"succeeded"`
+	Failed     int               `json:"failed"`
+	DurationMs float64           `json:"duration_ms"`
+	Throughput float64           `json:"throughput"`
+	MinMs      float64           `json:"min_ms"`
+	MeanMs     float64           `json:"mean_ms"`
+	MaxMs      float64           `json:"max_ms"`
+	P50Ms      float64           `json:"p50_ms"`
+	P90Ms      float64           `json:"p90_ms"`
+	P99Ms      float64           `json:"p99_ms"`
+	P999Ms     float64           `json:"p99_9_ms"`
+	Errors     map[string]int    `json:"errors"`
+	Histogram  []HistogramBucket `json:"histogram"`
+}
+
+// Report summarizes the requests recorded so far.
+func (s *Stats) Report() Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	latencies := make([]time.Duration, len(s.latencies))
+	copy(latencies, s.latencies)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	report := Report{Succeeded: len(latencies), Errors: map[string]int{}}
+	for key, count := range s.errors {
+		report.Errors[key] = count
+		report.Failed += count
+	}
+	report.Requests = report.Succeeded + report.Failed
+
+	end := s.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if !s.start.IsZero() {
+		duration := end.Sub(s.start)
+		report.DurationMs = milliseconds(duration)
+		if duration > 0 {
+			report.Throughput = float64(report.Requests) / duration.Seconds()
+		}
+	}
+
+	if len(latencies) == 0 {
+		return report
+	}
+
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+
+	report.MinMs = milliseconds(latencies[0])
+	report.MaxMs = milliseconds(latencies[len(latencies)-1])
+	report.MeanMs = milliseconds(total / time.Duration(len(latencies)))
+	report.P50Ms = milliseconds(percentile(latencies, 50))
+	report.P90Ms = milliseconds(percentile(latencies, 90))
+	report.P99Ms = milliseconds(percentile(latencies, 99))
+	report.P999Ms = milliseconds(percentile(latencies, 99.9))
+	report.Histogram = histogram(latencies)
+	return report
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// percentile uses the nearest-rank method on sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	// the epsilon keeps float rounding from pushing e.g. 99.9% of 1000 to 1000
+	rank := int(math.Ceil(p*float64(len(sorted))/100 - 1e-9))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// histogram counts sorted latencies in buckets following a 1-2-5 series, from
+// the first to the last non-empty bucket.
+func histogram(sorted []time.Duration) []HistogramBucket {
+	var buckets []HistogramBucket
+	i := 0
+	for bound := 10 * time.Microsecond; i < len(sorted); {
+		for _, step := range []time.Duration{1, 2, 5} {
+			upper := bound * step
+			count := 0
+			for i < len(sorted) && sorted[i] <= upper {
+				count++
+				i++
+			}
+			if count > 0 || len(buckets) > 0 {
+				buckets = append(buckets, HistogramBucket{UpperMs: milliseconds(upper), Count: count})
+			}
+			if i == len(sorted) {
+				break
+			}
+		}
+		bound *= 10
+	}
+	return buckets
+}
+
+// WriteText writes the report in a human readable form.
+func (r Report) WriteText(w io.Writer) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "requests:   %d (%d succeeded, %d failed)\n", r.Requests, r.Succeeded, r.Failed)
+	fmt.Fprintf(&b, "duration:   %.3fms\n", r.DurationMs)
+	fmt.Fprintf(&b, "throughput: %.2f/s\n", r.Throughput)
+	fmt.Fprintf(&b, "latency:    min=%.3fms mean=%.3fms max=%.3fms\n", r.MinMs, r.MeanMs, r.MaxMs)
+	fmt.Fprintf(&b, "            p50=%.3fms p90=%.3fms p99=%.3fms p99.9=%.3fms\n",
+		r.P50Ms, r.P90Ms, r.P99Ms, r.P999Ms)
+
+	for _, key := range sortedKeys(r.Errors) {
+		fmt.Fprintf(&b, "error:      %s x%d\n", key, r.Errors[key])
+	}
+
+	maxCount := 0
+	for _, bucket := range r.Histogram {
+		if bucket.Count > maxCount {
+			maxCount = bucket.Count
+		}
+	}
+	for _, bucket := range r.Histogram {
+		bar := 0
+		if maxCount > 0 {
+			bar = bucket.Count * 40 / maxCount
+		}
+		fmt.Fprintf(&b, "  <= %10.3fms %8d %s\n", bucket.UpperMs, bucket.Count, strings.Repeat("#", bar))
+	}
+
+	_, err := b.WriteTo(w)
+	return err
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteJSON writes the report as an indented JSON object.
+func (r Report) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return &SerializationError{Err: err}
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteCSV writes the report as metric,value rows. Errors are written as
+// error:<uri> and histogram buckets as le_ms:<upper bound>.
+func (r Report) WriteCSV(w io.Writer) error {
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+
+	rows := [][]string{
+		{"metric", "value"},
+		{"requests", strconv.Itoa(r.Requests)},
+		{"succeeded", strconv.Itoa(r.Succeeded)},
+		{"failed", strconv.Itoa(r.Failed)},
+		{"duration_ms", formatFloat(r.DurationMs)},
+		{"throughput", formatFloat(r.Throughput)},
+		{"min_ms", formatFloat(r.MinMs)},
+		{"mean_ms", formatFloat(r.MeanMs)},
+		{"max_ms", formatFloat(r.MaxMs)},
+		{"p50_ms", formatFloat(r.P50Ms)},
+		{"p90_ms", formatFloat(r.P90Ms)},
+		{"p99_ms", formatFloat(r.P99Ms)},
+		{"p99_9_ms", formatFloat(r.P999Ms)},
+	}
+	for _, key := range sortedKeys(r.Errors) {
+		rows = append(rows, []string{"error:" + key, strconv.Itoa(r.Errors[key])})
+	}
+	for _, bucket := range r.Histogram {
+		rows = append(rows, []string{"le_ms:" + formatFloat(bucket.UpperMs), strconv.Itoa(bucket.Count)})
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(rows); err != nil {
+		return &SerializationError{Err: err}
+	}
+	return nil
+}
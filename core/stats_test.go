@@ -0,0 +1,136 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestStatsReport(t *testing.T) {
+	stats := NewStats()
+	stats.begin(time.Now())
+	for i := 1; i <= 1000; i++ {
+		stats.add(time.Duration(i)*time.Millisecond, nil)
+	}
+	stats.add(0, &WampError{URI: "app.error.failed"})
+	stats.add(0, &WampError{URI: "app.error.failed"})
+	stats.add(0, context.Canceled)
+	stats.finish()
+
+	report := stats.Report()
+	if report.Requests != 1002 || report.Succeeded != 1000 || report.Failed != 2 {
+		t.Errorf("wrong counts %+v", report)
+	}
+
+	if report.MinMs != 1 || report.MaxMs != 1000 || report.MeanMs != 500.5 {
+		t.Errorf("wrong min/mean/max %v/%v/%v", report.MinMs, report.MeanMs, report.MaxMs)
+	}
+
+	if report.P50Ms != 500 || report.P90Ms != 900 || report.P99Ms != 990 || report.P999Ms != 999 {
+		t.Errorf("wrong percentiles %v/%v/%v/%v", report.P50Ms, report.P90Ms, report.P99Ms, report.P999Ms)
+	}
+
+	if report.Errors["app.error.failed"] != 2 {
+		t.Errorf("wrong error counts %v", report.Errors)
+	}
+
+	total := 0
+	for _, bucket := range report.Histogram {
+		total += bucket.Count
+	}
+	if total != 1000 {
+		t.Errorf("histogram counts %d latencies, expected 1000", total)
+	}
+}
+
+func TestStatsExport(t *testing.T) {
+	stats := NewStats()
+	stats.add(2*time.Millisecond, nil)
+	stats.add(0, &WampError{URI: "app.error.failed"})
+	report := stats.Report()
+
+	var buffer bytes.Buffer
+	if err := report.WriteJSON(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buffer.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Requests != 2 || decoded.P50Ms != 2 {
+		t.Errorf("unexpected JSON report %s", buffer.String())
+	}
+
+	buffer.Reset()
+	if err := report.WriteCSV(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []string{"metric,value\n", "requests,2\n", "error:app.error.failed,1\n", "le_ms:2,1\n"} {
+		if !strings.Contains(buffer.String(), row) {
+			t.Errorf("missing %q in CSV report %q", row, buffer.String())
+		}
+	}
+}
+
+func TestCallStatsContinuesPastErrors(t *testing.T) {
+	_, url, _ := startTestRouter(t, RouterConfig{Anonymous: true})
+	callee := connectTestSession(t, url)
+	caller := connectTestSession(t, url)
+
+	calls := 0
+	err := callee.Register("foo.flaky", func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+		calls++
+		if calls%2 == 0 {
+			return client.InvokeResult{Err: "app.error.flaky"}
+		}
+		return client.InvokeResult{}
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := NewStats()
+	err = Call(context.Background(), caller, NewOutput(&bytes.Buffer{}, FormatText), CallParams{
+		Procedure: "foo.flaky", Repeat: 10, Stats: stats})
+
+	var wampError *WampError
+	if !errors.As(err, &wampError) || wampError.URI != "app.error.flaky" {
+		t.Errorf("expected the first error to be returned, got %v", err)
+	}
+
+	report := stats.Report()
+	if report.Requests != 10 || report.Errors["app.error.flaky"] != 5 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "wick"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certFile, keyFile := writeCertificate(t)
+
+	tlsConfig, err := NewTLSConfig(certFile, certFile, keyFile, "router.local", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tlsConfig.RootCAs == nil {
+		t.Error("CA certificate not loaded")
+	}
+
+	if len(tlsConfig.Certificates) != 1 {
+		t.Error("client certificate not loaded")
+	}
+
+	if tlsConfig.ServerName != "router.local" || !tlsConfig.InsecureSkipVerify {
+		t.Error("server name or insecure mode not set")
+	}
+}
+
+func TestNewTLSConfigClientCertWithoutKey(t *testing.T) {
+	certFile, _ := writeCertificate(t)
+
+	if _, err := NewTLSConfig("", certFile, "", "", false); err == nil {
+		t.Error("client certificate without key must fail")
+	}
+}
+
+func TestNewTLSConfigMissingCA(t *testing.T) {
+	if _, err := NewTLSConfig(filepath.Join(t.TempDir(), "missing.pem"), "", "", "", false); err == nil {
+		t.Error("missing CA file must fail")
+	}
+}
+
+func TestDialerTLSOnlyForSecureURL(t *testing.T) {
+	tlsConfig, _ := NewTLSConfig("", "", "", "", true)
+
+	dialer := Dialer{URL: "ws://localhost:8080/ws", Realm: realm, TLSConfig: tlsConfig}
+	if cfg, _ := dialer.config(); cfg.TlsCfg != nil {
+		t.Error("TLS must not be used for ws://")
+	}
+
+	dialer.URL = "rss://localhost:8081"
+	if cfg, _ := dialer.config(); cfg.TlsCfg != tlsConfig {
+		t.Error("TLS must be used for rss://")
+	}
+}